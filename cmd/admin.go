@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"os"
+)
+
+// adminListenerEnabled returns whether operational endpoints are served by a separate admin listener.
+// if enabled, the public listener serves discovery and keys only.
+func adminListenerEnabled(config *viper.Viper) bool {
+	return config.GetInt("admin.port") != 0 || config.GetString("admin.socket") != ""
+}
+
+// adminSettings decide the listeners and where operational endpoints are routed. they are applied on start only.
+type adminSettings struct {
+	Admin         AdminConfig
+	LevelEndpoint bool
+}
+
+func currentAdminSettings(config *viper.Viper) adminSettings {
+	return adminSettings{
+		Admin: AdminConfig{
+			Address: config.GetString("admin.address"),
+			Port:    config.GetInt("admin.port"),
+			Socket:  config.GetString("admin.socket"),
+		},
+		LevelEndpoint: config.GetBool("log.levelEndpoint"),
+	}
+}
+
+// listenAdmin listens on admin.socket if set, otherwise on admin.port
+func listenAdmin(config *viper.Viper) (net.Listener, error) {
+	socket := config.GetString("admin.socket")
+	if socket == "" {
+		return net.Listen("tcp", fmt.Sprintf("%s:%d", config.GetString("admin.address"), config.GetInt("admin.port")))
+	}
+
+	// a socket file left by a previous process makes listen fail
+	if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(socket); err != nil {
+			return nil, errors.Wrapf(err, "failed to remove stale socket %s", socket)
+		}
+	}
+
+	return net.Listen("unix", socket)
+}
+
+func serveAdmin(handler http.Handler) {
+	listener, err := listenAdmin(viper.GetViper())
+	if err != nil {
+		zap.S().Fatalf("failed to listen admin. %v", err)
+	}
+
+	zap.S().Infof("starting admin server on %s\n", listener.Addr())
+	if err := http.Serve(listener, handler); err != nil {
+		zap.S().Fatalf("failed to start admin server. %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	stderrors "errors"
+	"fmt"
+	"github.com/krafton-hq/oidc-discovery-server/issuer_provider"
+	"github.com/krafton-hq/oidc-discovery-server/key_provider"
+	"github.com/krafton-hq/oidc-discovery-server/server"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/labels"
+	"net/url"
+	"strings"
+)
+
+// Config is the schema of the config file. see config.yaml for documentation of every key.
+// providers are still built from viper, the schema is only used to reject unknown keys and invalid values.
+type Config struct {
+	Issuer  string   `mapstructure:"issuer"`
+	Port    int      `mapstructure:"port"`
+	Issuers []string `mapstructure:"issuers"`
+	// ForwardedHeaders derives issuer and jwks_uri of discovery documents from X-Forwarded-* headers of Forwarded.TrustedProxies
+	ForwardedHeaders bool                   `mapstructure:"forwardedHeaders"`
+	Forwarded        server.ForwardedConfig `mapstructure:"forwarded"`
+	// DeprecatedMaxTTLSeconds is keyProvider.http.maxTTLSeconds of config files before it was moved. see migrateDeprecatedKeys
+	DeprecatedMaxTTLSeconds int `mapstructure:"maxTTLSeconds"`
+
+	Log   LogConfig   `mapstructure:"log"`
+	Admin AdminConfig `mapstructure:"admin"`
+
+	// ProvidersConfig of the default issuer. ignored if VirtualIssuers is set
+	ProvidersConfig `mapstructure:",squash"`
+	VirtualIssuers  []VirtualIssuerConfig `mapstructure:"virtualIssuers"`
+}
+
+// ProvidersConfig configures the issuer and key providers of an issuer
+type ProvidersConfig struct {
+	IssuerNormalization *issuer_provider.IssuerNormalizer   `mapstructure:"issuerNormalization"`
+	IssuerPolicy        *issuer_provider.IssuerPolicyConfig `mapstructure:"issuerPolicy"`
+	IssuerProvider      IssuerProviderConfig                `mapstructure:"issuerProvider"`
+	KeyProvider         KeyProviderConfig                   `mapstructure:"keyProvider"`
+}
+
+type VirtualIssuerConfig struct {
+	Name   string `mapstructure:"name"`
+	Issuer string `mapstructure:"issuer"`
+	// MatchHost routes requests by Host header in addition to the path of Issuer
+	MatchHost bool `mapstructure:"matchHost"`
+
+	ProvidersConfig `mapstructure:",squash"`
+}
+
+// AdminConfig configures the listener of operational endpoints. it is disabled unless Port or Socket is set.
+type AdminConfig struct {
+	Address string `mapstructure:"address"`
+	Port    int    `mapstructure:"port"`
+	Socket  string `mapstructure:"socket"`
+}
+
+type LogConfig struct {
+	Level         string `mapstructure:"level"`
+	Format        string `mapstructure:"format"`
+	AccessLog     bool   `mapstructure:"accessLog"`
+	LevelEndpoint bool   `mapstructure:"levelEndpoint"`
+}
+
+type IssuerProviderConfig struct {
+	Static *StaticIssuerProviderConfig `mapstructure:"static"`
+	HTTP   *HTTPIssuerProviderConfig   `mapstructure:"http"`
+	K8S    *K8SIssuerProviderConfig    `mapstructure:"k8s"`
+}
+
+type StaticIssuerProviderConfig struct {
+	Issuers []string `mapstructure:"issuers"`
+}
+
+type HTTPIssuerProviderConfig struct {
+	Endpoint   string                               `mapstructure:"endpoint"`
+	GJsonQuery string                               `mapstructure:"gjsonQuery"`
+	Endpoints  []issuer_provider.HTTPEndpointConfig `mapstructure:"endpoints"`
+
+	MaxTTLSeconds        int `mapstructure:"maxTTLSeconds"`
+	RetryIntervalSeconds int `mapstructure:"retryIntervalSeconds"`
+	TimeoutSeconds       int `mapstructure:"timeoutSeconds"`
+}
+
+type K8SIssuerProviderConfig struct {
+	Namespace             string `mapstructure:"namespace"`
+	LabelSelector         string `mapstructure:"labelSelector"`
+	ResyncSeconds         int    `mapstructure:"resyncSeconds"`
+	SyncTimeoutSeconds    int    `mapstructure:"syncTimeoutSeconds"`
+	StatusIntervalSeconds int    `mapstructure:"statusIntervalSeconds"`
+}
+
+type KeyProviderConfig struct {
+	HTTP *HTTPKeyProviderConfig `mapstructure:"http"`
+	K8S  *struct{}              `mapstructure:"k8s"`
+}
+
+type HTTPKeyProviderConfig struct {
+	MaxTTLSeconds        int                          `mapstructure:"maxTTLSeconds"`
+	MinTTLSeconds        int                          `mapstructure:"minTTLSeconds"`
+	DefaultKeyTTLSeconds int                          `mapstructure:"defaultKeyTTLSeconds"`
+	StrictIssuer         *bool                        `mapstructure:"strictIssuer"`
+	StrictKeys           bool                         `mapstructure:"strictKeys"`
+	Issuers              []key_provider.IssuerOptions `mapstructure:"issuers"`
+
+	Discovery struct {
+		DefaultTTLSeconds int `mapstructure:"defaultTTLSeconds"`
+		MaxTTLSeconds     int `mapstructure:"maxTTLSeconds"`
+	} `mapstructure:"discovery"`
+
+	Backoff struct {
+		InitialSeconds float64 `mapstructure:"initialSeconds"`
+		MaxSeconds     float64 `mapstructure:"maxSeconds"`
+		Multiplier     float64 `mapstructure:"multiplier"`
+		Jitter         float64 `mapstructure:"jitter"`
+	} `mapstructure:"backoff"`
+
+	CircuitBreaker struct {
+		FailureThreshold int     `mapstructure:"failureThreshold"`
+		OpenSeconds      float64 `mapstructure:"openSeconds"`
+	} `mapstructure:"circuitBreaker"`
+}
+
+// deprecatedKeys maps keys of older config files to their current location
+var deprecatedKeys = map[string][]string{
+	"maxTTLSeconds": {"keyProvider", "http", "maxTTLSeconds"},
+}
+
+// migrateDeprecatedKeys copies deprecated keys of the config file to their current location, unless it is set too.
+// it must be called whenever the config file is read.
+func migrateDeprecatedKeys(config *viper.Viper) {
+	for deprecated, path := range deprecatedKeys {
+		if !config.InConfig(deprecated) {
+			continue
+		}
+
+		current := strings.Join(path, ".")
+		if config.InConfig(current) {
+			zap.S().Warnf("%s is deprecated and ignored since %s is set. remove it from the config file", deprecated, current)
+			continue
+		}
+
+		zap.S().Warnf("%s is deprecated. move it to %s", deprecated, current)
+
+		value := config.Get(deprecated)
+		for i := len(path) - 1; i >= 0; i-- {
+			value = map[string]interface{}{path[i]: value}
+		}
+		if err := config.MergeConfigMap(value.(map[string]interface{})); err != nil {
+			zap.S().Errorf("failed to migrate %s. %v", deprecated, err)
+		}
+	}
+}
+
+// validateConfig checks config against the schema and returns every problem found, one per line
+func validateConfig(config *viper.Viper) error {
+	var schema Config
+	if err := config.UnmarshalExact(&schema); err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
+
+	return schema.Validate()
+}
+
+func (config Config) Validate() error {
+	var problems []error
+	problem := func(key string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", key, err))
+		}
+	}
+
+	problem("issuer", validateIssuerURL(config.Issuer))
+	if config.Port <= 0 || config.Port > 65535 {
+		problem("port", errors.Errorf("%d is out of range", config.Port))
+	}
+
+	if _, err := zapcore.ParseLevel(config.Log.Level); config.Log.Level != "" && err != nil {
+		problem("log.level", err)
+	}
+	if format := config.Log.Format; format != "" && format != logging.FormatJSON && format != logging.FormatConsole {
+		problem("log.format", errors.Errorf("unknown format %s. must be one of %s, %s", format, logging.FormatJSON, logging.FormatConsole))
+	}
+
+	if config.Admin.Port != 0 && config.Admin.Socket != "" {
+		problem("admin", errors.New("only one of port and socket can be set"))
+	}
+	if config.Admin.Port < 0 || config.Admin.Port > 65535 {
+		problem("admin.port", errors.Errorf("%d is out of range", config.Admin.Port))
+	} else if config.Admin.Port != 0 && config.Admin.Port == config.Port {
+		problem("admin.port", errors.Errorf("%d is already used by port", config.Admin.Port))
+	}
+
+	if config.ForwardedHeaders {
+		problem("forwarded", config.Forwarded.Validate())
+	}
+
+	problems = append(problems, config.ProvidersConfig.validate("")...)
+
+	names := make(map[string]struct{})
+	routes := make(map[string]string)
+	for i, virtualIssuer := range config.VirtualIssuers {
+		key := fmt.Sprintf("virtualIssuers[%d]", i)
+
+		if virtualIssuer.Name == "" {
+			problem(key+".name", errors.New("name is required"))
+		} else if _, ok := names[virtualIssuer.Name]; ok {
+			problem(key+".name", errors.Errorf("%s is already used", virtualIssuer.Name))
+		}
+		names[virtualIssuer.Name] = struct{}{}
+
+		if err := validateIssuerURL(virtualIssuer.Issuer); err != nil {
+			problem(key+".issuer", err)
+		} else {
+			route := virtualIssuerRoute(virtualIssuer)
+			if other, ok := routes[route]; ok {
+				problem(key+".issuer", errors.Errorf("%s is routed the same as %s", virtualIssuer.Issuer, other))
+			}
+			routes[route] = virtualIssuer.Issuer
+		}
+
+		problems = append(problems, virtualIssuer.ProvidersConfig.validate(key+".")...)
+	}
+
+	return stderrors.Join(problems...)
+}
+
+// validate returns problems of providers config. prefix is prepended to keys of problems.
+func (config ProvidersConfig) validate(prefix string) []error {
+	var problems []error
+	problem := func(key string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", key, err))
+		}
+	}
+
+	if config.IssuerNormalization != nil {
+		problem(prefix+"issuerNormalization", config.IssuerNormalization.Validate())
+	}
+	if config.IssuerPolicy != nil {
+		problem(prefix+"issuerPolicy", config.IssuerPolicy.Validate())
+	}
+
+	if http := config.IssuerProvider.HTTP; http != nil {
+		if http.Endpoint == "" && len(http.Endpoints) == 0 {
+			problem(prefix+"issuerProvider.http", errors.New("neither endpoint nor endpoints is set"))
+		}
+		if http.Endpoint != "" {
+			problem(prefix+"issuerProvider.http", issuer_provider.HTTPEndpointConfig{Endpoint: http.Endpoint, GJsonQuery: http.GJsonQuery}.Validate())
+		}
+		for i, endpoint := range http.Endpoints {
+			problem(prefix+fmt.Sprintf("issuerProvider.http.endpoints[%d]", i), endpoint.Validate())
+		}
+		problem(prefix+"issuerProvider.http.maxTTLSeconds", validateNonNegative(http.MaxTTLSeconds))
+		problem(prefix+"issuerProvider.http.retryIntervalSeconds", validateNonNegative(http.RetryIntervalSeconds))
+		problem(prefix+"issuerProvider.http.timeoutSeconds", validateNonNegative(http.TimeoutSeconds))
+	}
+
+	if k8s := config.IssuerProvider.K8S; k8s != nil {
+		if _, err := labels.Parse(k8s.LabelSelector); err != nil {
+			problem(prefix+"issuerProvider.k8s.labelSelector", err)
+		}
+	}
+
+	if http := config.KeyProvider.HTTP; http != nil {
+		problem(prefix+"keyProvider.http.maxTTLSeconds", validateNonNegative(http.MaxTTLSeconds))
+		problem(prefix+"keyProvider.http.minTTLSeconds", validateNonNegative(http.MinTTLSeconds))
+		if http.MaxTTLSeconds > 0 && http.MinTTLSeconds > http.MaxTTLSeconds {
+			problem(prefix+"keyProvider.http.minTTLSeconds", errors.Errorf("%d is greater than maxTTLSeconds %d", http.MinTTLSeconds, http.MaxTTLSeconds))
+		}
+		problem(prefix+"keyProvider.http.defaultKeyTTLSeconds", validateNonNegative(http.DefaultKeyTTLSeconds))
+		problem(prefix+"keyProvider.http.discovery.defaultTTLSeconds", validateNonNegative(http.Discovery.DefaultTTLSeconds))
+		problem(prefix+"keyProvider.http.discovery.maxTTLSeconds", validateNonNegative(http.Discovery.MaxTTLSeconds))
+
+		if http.Backoff.Multiplier != 0 && http.Backoff.Multiplier < 1 {
+			problem(prefix+"keyProvider.http.backoff.multiplier", errors.Errorf("%v is less than 1", http.Backoff.Multiplier))
+		}
+		if http.Backoff.Jitter < 0 || http.Backoff.Jitter > 1 {
+			problem(prefix+"keyProvider.http.backoff.jitter", errors.Errorf("%v is not between 0 and 1", http.Backoff.Jitter))
+		}
+		if http.Backoff.MaxSeconds != 0 && http.Backoff.MaxSeconds < http.Backoff.InitialSeconds {
+			problem(prefix+"keyProvider.http.backoff.maxSeconds", errors.Errorf("%v is less than initialSeconds %v", http.Backoff.MaxSeconds, http.Backoff.InitialSeconds))
+		}
+		problem(prefix+"keyProvider.http.circuitBreaker.failureThreshold", validateNonNegative(http.CircuitBreaker.FailureThreshold))
+
+		for i, options := range http.Issuers {
+			problem(prefix+fmt.Sprintf("keyProvider.http.issuers[%d]", i), options.Validate())
+		}
+	}
+
+	return problems
+}
+
+// virtualIssuerRoute returns what requests of virtualIssuer are routed by
+func virtualIssuerRoute(virtualIssuer VirtualIssuerConfig) string {
+	parsed, _ := url.Parse(virtualIssuer.Issuer)
+
+	host := ""
+	if virtualIssuer.MatchHost {
+		host = strings.ToLower(parsed.Hostname())
+	}
+
+	return host + strings.TrimSuffix(parsed.Path, "/")
+}
+
+// validateIssuerURL checks the issuer this server serves as. handlers are mounted under its path.
+func validateIssuerURL(issuer string) error {
+	parsed, err := url.Parse(issuer)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.Errorf("%q must be an http or https URL", issuer)
+	}
+	if parsed.Host == "" {
+		return errors.Errorf("%q has no host", issuer)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return errors.Errorf("%q must not have a query or fragment", issuer)
+	}
+
+	return nil
+}
+
+func validateNonNegative(value int) error {
+	if value < 0 {
+		return errors.Errorf("%d is negative", value)
+	}
+
+	return nil
+}
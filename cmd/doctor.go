@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/krafton-hq/oidc-discovery-server/doctor"
+	"github.com/krafton-hq/oidc-discovery-server/issuer_provider"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var doctorOutput string
+var doctorTimeout time.Duration
+var doctorCertExpiryThreshold time.Duration
+var doctorStrict bool
+var doctorVirtualIssuer string
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [issuer...]",
+	Short: "Probe issuers and exit non-zero on problems",
+	Long: "Checks DNS, TLS certificate chain and expiry, discovery document, jwks_uri, keys and cache headers of issuers.\n" +
+		"issuers given as arguments are checked instead of issuers from config, e.g. to check a new issuer before onboarding it.\n" +
+		"exits with 1 if any check fails, or any check warns with --strict.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if doctorOutput != OutputTable && doctorOutput != OutputJSON {
+			zap.S().Fatalf("unknown output %s. must be one of %s, %s", doctorOutput, OutputTable, OutputJSON)
+		}
+
+		if err := validateConfig(viper.GetViper()); err != nil {
+			zap.S().Fatalf("invalid config.\n%v", err)
+		}
+
+		reports, err := diagnose(args)
+		if err != nil {
+			zap.S().Fatalf("failed to diagnose issuers. %v", err)
+		}
+
+		if doctorOutput == OutputJSON {
+			err = printDoctorJSON(os.Stdout, reports)
+		} else {
+			err = printDoctorTable(os.Stdout, reports)
+		}
+		if err != nil {
+			zap.S().Fatalf("failed to print result. %v", err)
+		}
+
+		for _, report := range reports {
+			if report.Failed(doctorStrict) {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// diagnose diagnoses issuers, or every issuer of the issuer providers if none given, with settings of the key provider.
+// providers of the virtual issuer selected by --virtual-issuer are used.
+func diagnose(issuers []string) ([]doctor.Report, error) {
+	virtualIssuers, err := buildVirtualIssuers(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+	defer closeVirtualIssuers(virtualIssuers)
+
+	virtualIssuer, err := findVirtualIssuer(virtualIssuers, doctorVirtualIssuer)
+	if err != nil {
+		return nil, err
+	}
+	providers := virtualIssuer.Providers.Get()
+
+	reports := make([]doctor.Report, 0)
+
+	if len(issuers) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		trusted, err := providers.IssuerPolicy.Issuers(ctx)
+		cancel()
+		if err != nil {
+			zap.S().Warnf("error while getting issuers. continuing with %d resolved issuers: %v", len(trusted), err)
+		}
+
+		issuers = issuer_provider.IssuerURLs(trusted)
+		for _, rejected := range providers.IssuerPolicy.Rejected() {
+			reports = append(reports, doctor.Report{
+				Issuer:   rejected.Issuer,
+				Findings: []doctor.Finding{{Check: doctor.CheckPolicy, Severity: doctor.SeverityError, Message: rejected.Reason}},
+			})
+		}
+	} else {
+		trusted := make([]string, 0, len(issuers))
+		for _, issuer := range issuers {
+			if err := providers.IssuerPolicy.Check(issuer); err != nil {
+				reports = append(reports, doctor.Report{
+					Issuer:   issuer,
+					Findings: []doctor.Finding{{Check: doctor.CheckPolicy, Severity: doctor.SeverityError, Message: err.Error()}},
+				})
+				continue
+			}
+			trusted = append(trusted, issuer)
+		}
+		issuers = trusted
+	}
+
+	keyProvider := providers.HTTPKeyProvider
+	results := make([]doctor.Report, len(issuers))
+
+	var wg sync.WaitGroup
+	for i, issuer := range issuers {
+		i, issuer := i, issuer
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+			defer cancel()
+
+			results[i] = doctor.Diagnose(ctx, issuer, doctor.Options{
+				KeySetOptions:       keyProvider.KeySetOptions(issuer),
+				Spellings:           keyProvider.Spellings(ctx, issuer),
+				MinTTL:              time.Duration(keyProvider.MinTTLSeconds()) * time.Second,
+				MaxTTL:              time.Duration(keyProvider.MaxTTLSeconds()) * time.Second,
+				CertExpiryThreshold: doctorCertExpiryThreshold,
+			})
+		}()
+	}
+	wg.Wait()
+
+	reports = append(reports, results...)
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Issuer < reports[j].Issuer
+	})
+
+	return reports, nil
+}
+
+func printDoctorJSON(w io.Writer, reports []doctor.Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(reports)
+}
+
+func printDoctorTable(w io.Writer, reports []doctor.Report) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ISSUER\tCHECK\tSEVERITY\tMESSAGE")
+
+	for _, report := range reports {
+		for _, finding := range report.Findings {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", report.Issuer, finding.Check, finding.Severity, finding.Message)
+		}
+	}
+
+	return table.Flush()
+}
+
+func init() {
+	doctorCmd.Flags().StringVarP(&doctorOutput, "output", "o", OutputTable, "Output format. table or json")
+	doctorCmd.Flags().DurationVar(&doctorTimeout, "timeout", 30*time.Second, "Timeout of diagnosing each issuer")
+	doctorCmd.Flags().DurationVar(&doctorCertExpiryThreshold, "cert-expiry-threshold", 14*24*time.Hour, "Warn about certificates expiring within this duration")
+	doctorCmd.Flags().BoolVar(&doctorStrict, "strict", false, "Exit non-zero on warnings too")
+	doctorCmd.Flags().StringVar(&doctorVirtualIssuer, "virtual-issuer", "", "Name of the virtual issuer whose providers are used (default is the first one)")
+	rootCmd.AddCommand(doctorCmd)
+}
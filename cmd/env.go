@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// EnvPrefix of environment variables overriding config keys, e.g. ODS_KEYPROVIDER_HTTP_MAXTTLSECONDS for keyProvider.http.maxTTLSeconds
+const EnvPrefix = "ODS"
+
+// bindEnv lets environment variables override every key of the Config schema. other ODS_ variables are ignored,
+// e.g. ODS_SERVICE_HOST set by kubernetes for a Service named ods.
+// viper.Sub ignores sections absent from the config file, so keys of present environment variables are registered as defaults too.
+// this allows running without any config file.
+func bindEnv(config *viper.Viper) {
+	config.SetEnvPrefix(EnvPrefix)
+	config.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		// error only if no key is given
+		_ = config.BindEnv(key)
+
+		if value, ok := os.LookupEnv(EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))); ok {
+			config.SetDefault(key, value)
+		}
+	}
+}
+
+// configKeys returns keys of every setting of schema, a struct with mapstructure tags.
+// lists of objects, e.g. virtualIssuers, can't be given as environment variables and are skipped.
+func configKeys(schema reflect.Type, prefix string) []string {
+	keys := make([]string, 0)
+
+	for i := 0; i < schema.NumField(); i++ {
+		field := schema.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		switch {
+		case options == "squash":
+			keys = append(keys, configKeys(fieldType, prefix)...)
+		case name == "":
+		case fieldType.Kind() == reflect.Struct:
+			keys = append(keys, configKeys(fieldType, prefix+name+".")...)
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct:
+		default:
+			keys = append(keys, prefix+name)
+		}
+	}
+
+	return keys
+}
+
+// getStringList returns the list of key. a single string, e.g. of an environment variable, is split by commas like the config schema does.
+func getStringList(config *viper.Viper, key string) []string {
+	value, ok := config.Get(key).(string)
+	if !ok {
+		return config.GetStringSlice(key)
+	}
+
+	values := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+
+	return values
+}
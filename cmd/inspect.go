@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/krafton-hq/oidc-discovery-server/issuer_provider"
+	"github.com/krafton-hq/oidc-discovery-server/key_provider"
+	"github.com/krafton-hq/oidc-discovery-server/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+var inspectOutput string
+var inspectTimeout time.Duration
+
+// InspectResult is the result of fetching keys of a single issuer
+type InspectResult struct {
+	VirtualIssuer string            `json:"virtualIssuer"`
+	Issuer        string            `json:"issuer"`
+	Source        string            `json:"source,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	DiscoveryURL  string            `json:"discoveryUrl,omitempty"`
+	JwksURI       string            `json:"jwksUri,omitempty"`
+	Keys          []InspectKey      `json:"keys"`
+	TTLSeconds    int               `json:"ttlSeconds"`
+	Error         string            `json:"error,omitempty"`
+}
+
+type InspectKey struct {
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use,omitempty"`
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Fetch keys of every trusted issuer once and print the result",
+	Long: "Builds the same issuer and key providers as the server from config, fetches keys of every trusted issuer once\n" +
+		"and prints discovery URL, jwks_uri, kids, algs, TTL and errors of each issuer. keys of other key providers, e.g. k8s, are printed too.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if inspectOutput != OutputTable && inspectOutput != OutputJSON {
+			zap.S().Fatalf("unknown output %s. must be one of %s, %s", inspectOutput, OutputTable, OutputJSON)
+		}
+
+		if err := validateConfig(viper.GetViper()); err != nil {
+			zap.S().Fatalf("invalid config.\n%v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), inspectTimeout)
+		defer cancel()
+
+		results, err := inspect(ctx)
+		if err != nil {
+			zap.S().Fatalf("failed to inspect issuers. %v", err)
+		}
+
+		if inspectOutput == OutputJSON {
+			err = printInspectJSON(os.Stdout, results)
+		} else {
+			err = printInspectTable(os.Stdout, results)
+		}
+		if err != nil {
+			zap.S().Fatalf("failed to print result. %v", err)
+		}
+	},
+}
+
+// inspect fetches keys of every issuer of every virtual issuer once. issuers rejected by the issuer policy are reported as errors.
+func inspect(ctx context.Context) ([]InspectResult, error) {
+	virtualIssuers, err := buildVirtualIssuers(viper.GetViper())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build providers")
+	}
+	defer closeVirtualIssuers(virtualIssuers)
+
+	results := make([]InspectResult, 0)
+	for _, virtualIssuer := range virtualIssuers {
+		results = append(results, inspectVirtualIssuer(ctx, virtualIssuer.Name, virtualIssuer.Providers.Get())...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].VirtualIssuer != results[j].VirtualIssuer {
+			return results[i].VirtualIssuer < results[j].VirtualIssuer
+		}
+		return results[i].Issuer < results[j].Issuer
+	})
+
+	return results, nil
+}
+
+func inspectVirtualIssuer(ctx context.Context, name string, providers *server.Providers) []InspectResult {
+	issuers, err := providers.IssuerPolicy.Issuers(ctx)
+	if err != nil {
+		zap.S().Warnf("error while getting issuers of %s. continuing with %d resolved issuers: %v", name, len(issuers), err)
+	}
+
+	results := make([]InspectResult, len(issuers))
+
+	var wg sync.WaitGroup
+	for i, issuer := range issuers {
+		i, issuer := i, issuer
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = inspectIssuer(ctx, providers.HTTPKeyProvider, issuer)
+		}()
+	}
+	wg.Wait()
+
+	// keys of other key providers, e.g. service account keys of the cluster, are served at /keys as well
+	if chain, ok := providers.KeyProvider.(*key_provider.ChainKeyProvider); ok {
+		for _, keyProvider := range chain.Providers() {
+			if keyProvider == op.KeyProvider(providers.HTTPKeyProvider) {
+				continue
+			}
+			results = append(results, inspectKeyProvider(ctx, keyProvider))
+		}
+	}
+
+	for _, rejected := range providers.IssuerPolicy.Rejected() {
+		results = append(results, InspectResult{
+			Issuer: rejected.Issuer,
+			Keys:   []InspectKey{},
+			Error:  fmt.Sprintf("rejected by issuer policy: %s", rejected.Reason),
+		})
+	}
+
+	for i := range results {
+		results[i].VirtualIssuer = name
+	}
+
+	return results
+}
+
+func inspectIssuer(ctx context.Context, keyProvider *key_provider.HTTPKeyProvider, issuer issuer_provider.Issuer) InspectResult {
+	result := InspectResult{
+		Issuer: issuer.URL,
+		Source: issuer.Source,
+		Labels: issuer.Labels,
+		Keys:   []InspectKey{},
+	}
+
+	keySet, err := keyProvider.GetKeySet(ctx, issuer, true)
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	status, ok := keyProvider.KeySetStatus(issuer.URL)
+	if !ok {
+		return result
+	}
+
+	result.DiscoveryURL = status.DiscoveryURL
+	result.JwksURI = status.JwksURI
+	if ttl := time.Until(status.NextRefresh); ttl > 0 {
+		result.TTLSeconds = int(ttl.Round(time.Second).Seconds())
+	}
+
+	if keySet != nil {
+		result.Keys = inspectKeys(keySet.Keys())
+	}
+
+	return result
+}
+
+// inspectKeyProvider fetches keys of a key provider not backed by trusted issuers
+func inspectKeyProvider(ctx context.Context, keyProvider op.KeyProvider) InspectResult {
+	result := InspectResult{
+		Issuer: fmt.Sprint(keyProvider),
+		Source: "keyProvider",
+		Keys:   []InspectKey{},
+	}
+
+	keys, err := keyProvider.KeySet(ctx)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Keys = inspectKeys(keys)
+
+	return result
+}
+
+func inspectKeys(keys []op.Key) []InspectKey {
+	inspected := make([]InspectKey, 0, len(keys))
+	for _, key := range keys {
+		inspected = append(inspected, InspectKey{
+			Kid: key.ID(),
+			Alg: string(key.Algorithm()),
+			Use: key.Use(),
+		})
+	}
+	sort.Slice(inspected, func(i, j int) bool {
+		return inspected[i].Kid < inspected[j].Kid
+	})
+
+	return inspected
+}
+
+func printInspectJSON(w io.Writer, results []InspectResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(results)
+}
+
+func printInspectTable(w io.Writer, results []InspectResult) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "VIRTUAL ISSUER\tISSUER\tDISCOVERY URL\tJWKS URI\tKIDS\tALGS\tTTL\tERROR")
+
+	for _, result := range results {
+		kids := make([]string, 0, len(result.Keys))
+		algs := make([]string, 0, len(result.Keys))
+		for _, key := range result.Keys {
+			kids = append(kids, key.Kid)
+			algs = append(algs, key.Alg)
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			result.VirtualIssuer,
+			result.Issuer,
+			orDash(result.DiscoveryURL),
+			orDash(result.JwksURI),
+			orDash(strings.Join(kids, ",")),
+			orDash(strings.Join(algs, ",")),
+			(time.Duration(result.TTLSeconds) * time.Second).String(),
+			orDash(result.Error),
+		)
+	}
+
+	return table.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+func init() {
+	inspectCmd.Flags().StringVarP(&inspectOutput, "output", "o", OutputTable, "Output format. table or json")
+	inspectCmd.Flags().DurationVar(&inspectTimeout, "timeout", 30*time.Second, "Timeout of fetching all issuers")
+	rootCmd.AddCommand(inspectCmd)
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"context"
+	"github.com/krafton-hq/oidc-discovery-server/issuer_provider"
+	"github.com/krafton-hq/oidc-discovery-server/key_provider"
+	"github.com/krafton-hq/oidc-discovery-server/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"go.uber.org/zap"
+)
+
+// buildProviders builds issuer and key provider chains from config. key sets are shared through cache.
+// on error, every resource created so far is released.
+func buildProviders(config *viper.Viper, cache *key_provider.KeySetCache) (providers *server.Providers, err error) {
+	providers = &server.Providers{}
+	defer func() {
+		if err != nil {
+			providers.Close()
+		}
+	}()
+
+	normalizer, err := issuer_provider.NewIssuerNormalizer(config.Sub("issuerNormalization"))
+	if err != nil {
+		return nil, err
+	}
+
+	issuerProviders := make([]issuer_provider.IssuerProvider, 0)
+
+	// --issuers flag or ODS_ISSUERS
+	issuerProviders = append(issuerProviders, issuer_provider.NewFileIssuerProvider("flag", config))
+	if sub := config.Sub("issuerProvider.http"); sub != nil {
+		zap.S().Debugln("adding http issuer provider")
+		zap.S().Debugln(sub)
+		issuerProviders = append(issuerProviders, issuer_provider.NewHTTPIssuerProvider(sub))
+	}
+	if sub := config.Sub("issuerProvider.static"); sub != nil {
+		zap.S().Debugln("adding static issuer provider")
+		zap.S().Debugln(sub)
+		issuerProviders = append(issuerProviders, issuer_provider.NewFileIssuerProvider("static", sub))
+	}
+
+	var k8sIssuerProvider *issuer_provider.K8SIssuerProvider
+	if sub := config.Sub("issuerProvider.k8s"); sub != nil {
+		zap.S().Debugln("adding k8s issuer provider")
+		zap.S().Debugln(sub)
+
+		k8sIssuerProvider, err = issuer_provider.NewK8SIssuerProvider(sub)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create k8s issuer provider")
+		}
+		providers.OnClose(k8sIssuerProvider.Close)
+		issuerProviders = append(issuerProviders, k8sIssuerProvider)
+	}
+
+	issuerProvider, err := issuer_provider.NewPolicyIssuerProvider(
+		issuer_provider.NewChainIssuerProvider(normalizer, issuerProviders...),
+		normalizer,
+		config.Sub("issuerPolicy"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create issuer policy")
+	}
+
+	keyProviders := make([]op.KeyProvider, 0)
+
+	httpKeyProvider := key_provider.NewHTTPKeyProvider(issuerProvider, normalizer, cache, config.Sub("keyProvider.http"))
+	keyProviders = append(keyProviders, httpKeyProvider)
+	providers.OnClose(httpKeyProvider.Close)
+	if sub := config.Sub("keyProvider.k8s"); sub != nil {
+		zap.S().Debugln("adding k8s key provider")
+		zap.S().Debugln(sub)
+
+		provider, err := key_provider.NewK8SKeyProvider()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create k8s key provider")
+		}
+		keyProviders = append(keyProviders, provider)
+	}
+
+	if k8sIssuerProvider != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		providers.OnClose(cancel)
+		go k8sIssuerProvider.ReportStatus(ctx, httpKeyProvider.KeySetStatus)
+	}
+
+	providers.KeyProvider = key_provider.NewChainKeyProvider(keyProviders...)
+	providers.HTTPKeyProvider = httpKeyProvider
+	providers.IssuerPolicy = issuerProvider
+
+	return providers, nil
+}
+
+// DefaultVirtualIssuer is the name of the virtual issuer built from the root config if virtualIssuers is not set
+const DefaultVirtualIssuer = "default"
+
+type virtualIssuerConfig struct {
+	name      string
+	issuer    string
+	matchHost bool
+	config    *viper.Viper
+}
+
+// virtualIssuerConfigs returns config of every virtual issuer.
+// without virtualIssuers, the root config is the only virtual issuer, which also trusts --issuers flag.
+func virtualIssuerConfigs(config *viper.Viper) ([]virtualIssuerConfig, error) {
+	var entries []map[string]interface{}
+	if err := config.UnmarshalKey("virtualIssuers", &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to read virtualIssuers")
+	}
+
+	if len(entries) == 0 {
+		return []virtualIssuerConfig{{name: DefaultVirtualIssuer, issuer: config.GetString("issuer"), config: config}}, nil
+	}
+
+	configs := make([]virtualIssuerConfig, 0, len(entries))
+	for _, entry := range entries {
+		sub := viper.New()
+		if err := sub.MergeConfigMap(entry); err != nil {
+			return nil, errors.Wrap(err, "failed to read virtual issuer")
+		}
+
+		configs = append(configs, virtualIssuerConfig{
+			name:      sub.GetString("name"),
+			issuer:    sub.GetString("issuer"),
+			matchHost: sub.GetBool("matchHost"),
+			config:    sub,
+		})
+	}
+
+	return configs, nil
+}
+
+// buildVirtualIssuers builds providers of every virtual issuer. virtual issuers share a new key set cache,
+// so that an issuer trusted by several virtual issuers is fetched once. on error, every provider built so far is closed.
+func buildVirtualIssuers(config *viper.Viper) (virtualIssuers []*server.VirtualIssuer, err error) {
+	configs, err := virtualIssuerConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			closeVirtualIssuers(virtualIssuers)
+		}
+	}()
+
+	publicOnly := adminListenerEnabled(config)
+	cache := key_provider.NewKeySetCache()
+	for _, virtualIssuerConfig := range configs {
+		providers, err := buildProviders(virtualIssuerConfig.config, cache)
+		if err != nil {
+			return virtualIssuers, errors.Wrapf(err, "failed to build providers of %s", virtualIssuerConfig.name)
+		}
+
+		virtualIssuer, err := server.NewVirtualIssuer(virtualIssuerConfig.name, virtualIssuerConfig.issuer, virtualIssuerConfig.matchHost, providers, publicOnly)
+		if err != nil {
+			providers.Close()
+			return virtualIssuers, err
+		}
+
+		virtualIssuers = append(virtualIssuers, virtualIssuer)
+	}
+
+	return virtualIssuers, nil
+}
+
+func closeVirtualIssuers(virtualIssuers []*server.VirtualIssuer) {
+	for _, virtualIssuer := range virtualIssuers {
+		virtualIssuer.Providers.Get().Close()
+	}
+}
+
+// findVirtualIssuer returns the virtual issuer of name, or the first one if name is empty
+func findVirtualIssuer(virtualIssuers []*server.VirtualIssuer, name string) (*server.VirtualIssuer, error) {
+	for _, virtualIssuer := range virtualIssuers {
+		if name == "" || virtualIssuer.Name == name {
+			return virtualIssuer, nil
+		}
+	}
+
+	return nil, errors.Errorf("virtual issuer %s not found", name)
+}
+
+// reloadVirtualIssuers rebuilds virtual issuers from config and swaps them into router.
+// cached key sets of a virtual issuer are adopted by the new one of the same name.
+// the current virtual issuers are kept if the new config is invalid or changes admin settings the server started with.
+func reloadVirtualIssuers(router *server.IssuerRouter, config *viper.Viper, started adminSettings) {
+	if err := validateConfig(config); err != nil {
+		zap.S().Errorf("invalid config. keeping current providers.\n%v", err)
+		return
+	}
+
+	if current := currentAdminSettings(config); current != started {
+		zap.S().Errorf("admin and log.levelEndpoint changed from %+v to %+v. restart required. keeping current providers", started, current)
+		return
+	}
+
+	virtualIssuers, err := buildVirtualIssuers(config)
+	if err != nil {
+		zap.S().Errorf("failed to reload providers. keeping current providers. %v", err)
+		return
+	}
+
+	old := router.Get()
+	for _, virtualIssuer := range virtualIssuers {
+		if previous, err := findVirtualIssuer(old, virtualIssuer.Name); err == nil {
+			virtualIssuer.Providers.Get().HTTPKeyProvider.AdoptKeySets(previous.Providers.Get().HTTPKeyProvider)
+		}
+	}
+
+	router.Set(virtualIssuers)
+	closeVirtualIssuers(old)
+	zap.S().Infof("providers of %d virtual issuers reloaded", len(virtualIssuers))
+}
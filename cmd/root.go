@@ -1,17 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/mux"
-	"github.com/krafton-hq/oidc-discovery-server/issuer_provider"
-	"github.com/krafton-hq/oidc-discovery-server/key_provider"
 	"github.com/krafton-hq/oidc-discovery-server/server"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
 	"github.com/spf13/viper"
-	"github.com/zitadel/oidc/v2/pkg/op"
 	"go.uber.org/zap"
 	"net/http"
-	"net/url"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -19,65 +17,79 @@
 
 var Issuer string
 var Port int
+var ConfigFile string
 
 var rootCmd = &cobra.Command{
 	Use: "oidc-discovery-server",
 	Run: func(cmd *cobra.Command, args []string) {
 		zap.S().Info("initializing server...")
 
-		issuerParsed, err := url.Parse(Issuer)
-		if err != nil {
-			zap.S().Fatalf("issuer is not a valid URL. %v", err)
-		}
-
-		issuerProviders := make([]issuer_provider.IssuerProvider, 0)
-		if sub := viper.Sub("issuerProvider.http"); sub != nil {
-			zap.S().Debugln("adding http issuer provider")
-			zap.S().Debugln(sub)
-			issuerProviders = append(issuerProviders, issuer_provider.NewHTTPIssuerProvider(sub))
+		if err := validateConfig(viper.GetViper()); err != nil {
+			zap.S().Fatalf("invalid config.\n%v", err)
 		}
-		if sub := viper.Sub("issuerProvider.static"); sub != nil {
-			zap.S().Debugln("adding static issuer provider")
-			zap.S().Debugln(sub)
-			issuerProviders = append(issuerProviders, issuer_provider.NewFileIssuerProvider(sub))
-		}
-
-		issuerProvider := issuer_provider.NewChainIssuerProvider(issuerProviders...)
 
-		keyProviders := make([]op.KeyProvider, 0)
+		Issuer = viper.GetString("issuer")
+		Port = viper.GetInt("port")
 
-		httpKeyProvider := key_provider.NewHTTPKeyProvider(issuerProvider, viper.Sub("keyProvider.http"))
-		keyProviders = append(keyProviders, httpKeyProvider)
-		if sub := viper.Sub("keyProvider.k8s"); sub != nil {
-			zap.S().Debugln("adding k8s key provider")
-			zap.S().Debugln(sub)
-
-			provider, err := key_provider.NewK8SKeyProvider()
-			if err != nil {
-				zap.S().Fatalf("failed to create k8s key provider. %v", err)
-			} else {
-				keyProviders = append(keyProviders, provider)
-			}
+		virtualIssuers, err := buildVirtualIssuers(viper.GetViper())
+		if err != nil {
+			zap.S().Fatalf("failed to build providers. %v", err)
 		}
-
-		keyProvider := key_provider.NewChainKeyProvider(keyProviders...)
+		issuerRouter := server.NewIssuerRouter(virtualIssuers)
+		admin := currentAdminSettings(viper.GetViper())
+
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			zap.S().Infof("config file changed: %s. reloading providers.", e.Name)
+			migrateDeprecatedKeys(viper.GetViper())
+			// the level changed by LogLevelHandler is kept until the config file changes
+			if err := logging.SetLevel(viper.GetString("log.level")); err != nil {
+				zap.S().Errorf("invalid log level. keeping current level. %v", err)
+			}
+			reloadVirtualIssuers(issuerRouter, viper.GetViper(), admin)
+		})
+		viper.WatchConfig()
 
 		router := mux.NewRouter()
-		err = server.RegisterHandler(router, Issuer, keyProvider, httpKeyProvider)
-		if err != nil {
-			zap.S().Fatalf("failed to register handler. %v", err)
+		if adminListenerEnabled(viper.GetViper()) {
+			adminRouter := mux.NewRouter()
+			server.AdminHandler(adminRouter, issuerRouter)
+			go serveAdmin(withLogMiddlewares(adminRouter))
+		} else if viper.GetBool("log.levelEndpoint") {
+			server.LogLevelHandler(router)
 		}
-
-		http.Handle(issuerParsed.Path, router)
+		router.PathPrefix("/").Handler(issuerRouter)
+
+		handler := http.Handler(router)
+		if viper.GetBool("forwardedHeaders") {
+			// keys are read one by one, since a nested key bound to a flag is not unmarshaled with its section
+			forwardedMiddleware, err := server.ForwardedMiddleware(server.ForwardedConfig{
+				TrustedProxies: getStringList(viper.GetViper(), "forwarded.trustedProxies"),
+				AllowedHosts:   getStringList(viper.GetViper(), "forwarded.allowedHosts"),
+			})
+			if err != nil {
+				zap.S().Fatalf("invalid forwarded config. %v", err)
+			}
+			handler = forwardedMiddleware(handler)
+		}
+		handler = withLogMiddlewares(handler)
 
 		zap.S().Infof("starting server on port %d\n", Port)
-		err = http.ListenAndServe(fmt.Sprintf(":%d", Port), nil)
+		err = http.ListenAndServe(fmt.Sprintf(":%d", Port), handler)
 		if err != nil {
 			zap.S().Fatalf("failed to start server. %v", err)
 		}
 	},
 }
 
+// withLogMiddlewares adds request ID and access log, if enabled, to handler
+func withLogMiddlewares(handler http.Handler) http.Handler {
+	if viper.GetBool("log.accessLog") {
+		handler = server.AccessLogMiddleware(handler)
+	}
+
+	return server.RequestIDMiddleware(handler)
+}
+
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
@@ -88,24 +100,58 @@ func Execute() {
 func init() {
 	zap.ReplaceGlobals(zap.Must(zap.NewProduction()))
 
-	rootCmd.Flags().StringVar(&Issuer, "issuer", "https://localhost:8080/", "Issuer URL (NOTE: / suffix required if no PATH)")
+	rootCmd.Flags().StringVar(&Issuer, "issuer", "https://localhost:8080/", "Issuer URL. handlers are mounted under its path")
 	rootCmd.Flags().IntVarP(&Port, "port", "p", 8080, "Port")
 	rootCmd.Flags().StringSlice("issuers", []string{}, "Trusted issuers")
-	if err := viper.BindPFlag("issuers", rootCmd.Flags().Lookup("issuers")); err != nil {
-		panic(err)
+	rootCmd.Flags().Bool("forwarded-headers", false, "Derive issuer and jwks_uri from X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix")
+	rootCmd.Flags().StringSlice("trusted-proxies", []string{}, "CIDRs of proxies whose forwarded headers are used")
+	for name, flag := range map[string]string{"issuer": "issuer", "port": "port", "issuers": "issuers", "forwardedHeaders": "forwarded-headers", "forwarded.trustedProxies": "trusted-proxies"} {
+		if err := viper.BindPFlag(name, rootCmd.Flags().Lookup(flag)); err != nil {
+			panic(err)
+		}
+	}
+	bindEnv(viper.GetViper())
+
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level. debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", logging.FormatJSON, "Log format. json or console")
+	for name, flag := range map[string]string{"log.level": "log-level", "log.format": "log-format"} {
+		if err := viper.BindPFlag(name, rootCmd.PersistentFlags().Lookup(flag)); err != nil {
+			panic(err)
+		}
+	}
+	viper.SetDefault("log.accessLog", true)
+
+	rootCmd.PersistentFlags().StringVar(&ConfigFile, "config", "", "Config file (default is config.yaml in . or ./config)")
+	cobra.OnInitialize(initConfig)
+}
+
+// initConfig reads the config file. running without a config file is allowed unless --config is given,
+// since every key can be set by flags and environment variables.
+func initConfig() {
+	if ConfigFile != "" {
+		viper.SetConfigFile(ConfigFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.SetConfigType("yaml")
+		viper.AddConfigPath(".")
+		viper.AddConfigPath("config")
+	}
+
+	err := viper.ReadInConfig()
+
+	if err := logging.Setup(viper.GetString("log.level"), viper.GetString("log.format")); err != nil {
+		zap.S().Fatalf("invalid log config. %v", err)
 	}
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("config")
-	viper.WatchConfig()
+	if err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if ConfigFile == "" && errors.As(err, &notFound) {
+			zap.S().Warnf("config file not found. using flags and environment variables only. %v", err)
+			return
+		}
 
-	if err := viper.ReadInConfig(); err != nil {
-		zap.S().Warnf("failed to read config file. %v", err)
+		zap.S().Fatalf("failed to read config file. %v", err)
 	}
 
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		zap.S().Infof("config file changed: %s", e.Name)
-	})
+	migrateDeprecatedKeys(viper.GetViper())
 }
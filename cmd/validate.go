@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the config and exit non-zero if it is invalid",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := validateConfig(viper.GetViper()); err != nil {
+			fmt.Fprintf(os.Stderr, "%s is invalid.\n%v\n", configName(), err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s is valid\n", configName())
+	},
+}
+
+func configName() string {
+	if used := viper.ConfigFileUsed(); used != "" {
+		return used
+	}
+
+	return "config"
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
@@ -0,0 +1,330 @@
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/krafton-hq/oidc-discovery-server/jwt"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Severity string
+
+const (
+	SeverityOK      Severity = "ok"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+const (
+	CheckPolicy    = "policy"
+	CheckDNS       = "dns"
+	CheckTLS       = "tls"
+	CheckDiscovery = "discovery"
+	CheckJWKS      = "jwks"
+	CheckKeys      = "keys"
+	CheckCache     = "cache"
+)
+
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// Report is the result of diagnosing a single issuer
+type Report struct {
+	Issuer   string    `json:"issuer"`
+	Findings []Finding `json:"findings"`
+}
+
+func (report *Report) add(check string, severity Severity, format string, args ...interface{}) {
+	report.Findings = append(report.Findings, Finding{Check: check, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// Failed reports whether any finding is an error, or a warning if strict
+func (report Report) Failed(strict bool) bool {
+	for _, finding := range report.Findings {
+		if finding.Severity == SeverityError || (strict && finding.Severity == SeverityWarning) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Options are the key provider settings the issuer is diagnosed against
+type Options struct {
+	KeySetOptions jwt.KeySetOptions
+	// Spellings are other configured spellings of the canonical issuer, accepted as the issuer of the discovery document like the server does
+	Spellings []string
+	// MinTTL and MaxTTL bound the key cache TTL. cache headers outside of them are reported
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// CertExpiryThreshold reports certificates expiring within it
+	CertExpiryThreshold time.Duration
+
+	Client *http.Client
+}
+
+// Diagnose checks DNS, TLS, discovery document, jwks_uri, keys and cache headers of issuer.
+// checks depending on a failed check are skipped.
+func Diagnose(ctx context.Context, issuer string, options Options) Report {
+	report := Report{Issuer: issuer, Findings: make([]Finding, 0)}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" {
+		report.add(CheckDNS, SeverityError, "issuer is not an absolute URL")
+		return report
+	}
+
+	if !checkDNS(ctx, &report, parsed.Hostname()) {
+		return report
+	}
+	if !checkTLS(ctx, &report, parsed, options.CertExpiryThreshold) {
+		return report
+	}
+
+	discovery, ok := checkDiscovery(ctx, &report, issuer, options)
+	if !ok {
+		return report
+	}
+
+	body, header, ok := checkJWKS(ctx, &report, issuer, discovery.JwksURI, options.Client)
+	if !ok {
+		return report
+	}
+
+	checkKeys(&report, body)
+	checkCache(&report, header, options)
+
+	return report
+}
+
+func checkDNS(ctx context.Context, report *Report, host string) bool {
+	if net.ParseIP(host) != nil {
+		report.add(CheckDNS, SeverityOK, "%s is an IP address", host)
+		return true
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		report.add(CheckDNS, SeverityError, "failed to resolve %s: %v", host, err)
+		return false
+	}
+
+	report.add(CheckDNS, SeverityOK, "%s resolves to %s", host, strings.Join(addrs, ", "))
+	return true
+}
+
+func checkTLS(ctx context.Context, report *Report, issuer *url.URL, expiryThreshold time.Duration) bool {
+	if issuer.Scheme != "https" {
+		report.add(CheckTLS, SeverityWarning, "issuer is not https. most token verifiers reject it")
+		return true
+	}
+
+	port := issuer.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	dialer := tls.Dialer{Config: &tls.Config{ServerName: issuer.Hostname()}}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(issuer.Hostname(), port))
+	if err != nil {
+		report.add(CheckTLS, SeverityError, "TLS handshake failed: %v", err)
+		return false
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		report.add(CheckTLS, SeverityError, "no verified certificate chain")
+		return false
+	}
+
+	chain := state.VerifiedChains[0]
+	subjects := make([]string, 0, len(chain))
+	for _, cert := range chain {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+
+	// the earliest expiry in the chain is when verification starts failing
+	expiry := chain[0].NotAfter
+	for _, cert := range chain {
+		if cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+
+	remaining := time.Until(expiry)
+	if remaining < expiryThreshold {
+		report.add(CheckTLS, SeverityWarning, "certificate chain %s expires in %s, at %s", strings.Join(subjects, " <- "), remaining.Round(time.Hour), expiry.Format(time.RFC3339))
+	} else {
+		report.add(CheckTLS, SeverityOK, "certificate chain %s is valid until %s", strings.Join(subjects, " <- "), expiry.Format(time.RFC3339))
+	}
+
+	return true
+}
+
+type discoveryDocument struct {
+	Issuer                           string   `json:"issuer"`
+	JwksURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+func checkDiscovery(ctx context.Context, report *Report, issuer string, options Options) (discoveryDocument, bool) {
+	var document discoveryDocument
+
+	metadataPath := options.KeySetOptions.MetadataPath
+	if metadataPath == "" {
+		metadataPath = jwt.OIDCDocumentPath
+	}
+	documentURL, err := jwt.MetadataURL(issuer, metadataPath, options.KeySetOptions.MetadataPathInsertion)
+	if err != nil {
+		report.add(CheckDiscovery, SeverityError, "invalid metadata URL: %v", err)
+		return document, false
+	}
+
+	body, _, err := get(ctx, options.Client, documentURL)
+	if err != nil {
+		report.add(CheckDiscovery, SeverityError, "%v", err)
+		return document, false
+	}
+
+	if err := json.Unmarshal(body, &document); err != nil {
+		report.add(CheckDiscovery, SeverityError, "%s is not a JSON object: %v", documentURL, err)
+		return document, false
+	}
+
+	ok := true
+	if !matchesSpelling(document.Issuer, issuer, options.Spellings) {
+		if options.KeySetOptions.StrictIssuer {
+			report.add(CheckDiscovery, SeverityError, "issuer %q of the document does not match %q", document.Issuer, issuer)
+			ok = false
+		} else {
+			report.add(CheckDiscovery, SeverityWarning, "issuer %q of the document does not match %q. allowed since strictIssuer is off", document.Issuer, issuer)
+		}
+	}
+
+	if document.JwksURI == "" {
+		report.add(CheckDiscovery, SeverityError, "jwks_uri is missing")
+		return document, false
+	}
+
+	// required by OpenID Connect Discovery 1.0 section 3. keys can still be served without them.
+	missing := make([]string, 0)
+	if !options.KeySetOptions.MetadataPathInsertion {
+		if len(document.ResponseTypesSupported) == 0 {
+			missing = append(missing, "response_types_supported")
+		}
+		if len(document.SubjectTypesSupported) == 0 {
+			missing = append(missing, "subject_types_supported")
+		}
+		if len(document.IDTokenSigningAlgValuesSupported) == 0 {
+			missing = append(missing, "id_token_signing_alg_values_supported")
+		}
+	}
+	if len(missing) > 0 {
+		report.add(CheckDiscovery, SeverityWarning, "required fields are missing: %s", strings.Join(missing, ", "))
+	}
+
+	if ok {
+		report.add(CheckDiscovery, SeverityOK, "%s is valid. jwks_uri: %s", documentURL, document.JwksURI)
+	}
+
+	return document, ok
+}
+
+// matchesSpelling returns whether discovered is issuer or one of its spellings
+func matchesSpelling(discovered, issuer string, spellings []string) bool {
+	if discovered == issuer {
+		return true
+	}
+
+	for _, spelling := range spellings {
+		if discovered == spelling {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkJWKS(ctx context.Context, report *Report, issuer, jwksURI string, client *http.Client) ([]byte, http.Header, bool) {
+	parsedIssuer, _ := url.Parse(issuer)
+	parsedJwksURI, err := url.Parse(jwksURI)
+	if err != nil {
+		report.add(CheckJWKS, SeverityError, "invalid jwks_uri %s: %v", jwksURI, err)
+		return nil, nil, false
+	}
+
+	if parsedIssuer.Scheme == "https" && parsedJwksURI.Scheme != "https" {
+		report.add(CheckJWKS, SeverityWarning, "jwks_uri %s is not https while the issuer is", jwksURI)
+	}
+
+	body, header, err := get(ctx, client, jwksURI)
+	if err != nil {
+		report.add(CheckJWKS, SeverityError, "%v", err)
+		return nil, nil, false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != "application/jwk-set+json" {
+		report.add(CheckJWKS, SeverityWarning, "unexpected content type %q", header.Get("Content-Type"))
+	}
+
+	report.add(CheckJWKS, SeverityOK, "%s is reachable", jwksURI)
+	return body, header, true
+}
+
+func checkCache(report *Report, header http.Header, options Options) {
+	ttl := jwt.HeaderTTL(header, time.Now())
+
+	switch {
+	case ttl < 0:
+		report.add(CheckCache, SeverityWarning, "no Cache-Control max-age or Expires. the default key TTL applies")
+	case ttl < options.MinTTL:
+		report.add(CheckCache, SeverityWarning, "TTL %s is below minTTLSeconds. keys are cached for %s", ttl, options.MinTTL)
+	case options.MaxTTL > 0 && ttl > options.MaxTTL:
+		report.add(CheckCache, SeverityOK, "TTL %s is capped to maxTTLSeconds %s", ttl, options.MaxTTL)
+	default:
+		report.add(CheckCache, SeverityOK, "TTL %s", ttl)
+	}
+}
+
+func get(ctx context.Context, client *http.Client, rawURL string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to create request to %s", rawURL)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get %s", rawURL)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, errors.Errorf("unexpected status code %d from %s", res.StatusCode, rawURL)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read response of %s", rawURL)
+	}
+
+	return body, res.Header, nil
+}
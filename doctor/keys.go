@@ -0,0 +1,73 @@
+package doctor
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"github.com/krafton-hq/oidc-discovery-server/jwt"
+	"github.com/pkg/errors"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const minRSAKeyBits = 2048
+
+// checkKeys parses body as the key provider does in strict mode, so every key the server would skip is reported
+func checkKeys(report *Report, body []byte) {
+	jwks, err := jwt.ParseJWKSWithOptions(body, true)
+
+	var keyErrors jwt.KeyErrors
+	if errors.As(err, &keyErrors) {
+		for _, keyError := range keyErrors {
+			report.add(CheckKeys, SeverityError, "%v", keyError)
+		}
+		return
+	}
+	if err != nil {
+		report.add(CheckKeys, SeverityError, "%v", err)
+		return
+	}
+
+	if len(jwks.Keys) == 0 {
+		report.add(CheckKeys, SeverityError, "JWKS has no keys")
+		return
+	}
+
+	problems := false
+	for _, key := range jwks.Keys {
+		for _, finding := range checkKey(key) {
+			report.add(CheckKeys, finding.Severity, "key %s: %s", key.KeyID, finding.Message)
+			problems = true
+		}
+	}
+
+	if !problems {
+		report.add(CheckKeys, SeverityOK, "%d keys are valid", len(jwks.Keys))
+	}
+}
+
+// checkKey returns problems of a single key accepted by the parser, which verifiers may still reject
+func checkKey(key jose.JSONWebKey) []Finding {
+	findings := make([]Finding, 0)
+	add := func(severity Severity, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if key.Use != "" && key.Use != "sig" {
+		add(SeverityWarning, "use is %s, not sig", key.Use)
+	}
+	if !key.IsPublic() {
+		add(SeverityError, "contains private or symmetric key material")
+	}
+
+	// EC and Ed25519 keys are bound to a single algorithm, so alg is inferred if missing
+	if key.Algorithm == "" && jwt.InferAlgorithm(key.Key) == "" {
+		add(SeverityWarning, "alg is missing")
+	}
+
+	if public, ok := key.Key.(*rsa.PublicKey); ok {
+		if bits := public.N.BitLen(); bits < minRSAKeyBits {
+			add(SeverityError, "RSA key is %d bits, less than %d", bits, minRSAKeyBits)
+		}
+	}
+
+	return findings
+}
@@ -1,21 +1,61 @@
 package issuer_provider
 
+import (
+	"context"
+	"errors"
+)
+
 type ChainIssuerProvider struct {
-	providers []IssuerProvider
+	normalizer IssuerNormalizer
+	providers  []IssuerProvider
 }
 
-func NewChainIssuerProvider(providers ...IssuerProvider) *ChainIssuerProvider {
+func NewChainIssuerProvider(normalizer IssuerNormalizer, providers ...IssuerProvider) *ChainIssuerProvider {
 	return &ChainIssuerProvider{
-		providers: providers,
+		normalizer: normalizer,
+		providers:  providers,
 	}
 }
 
-func (provider *ChainIssuerProvider) Issuers() []string {
-	issuers := make([]string, 0)
+// Issuers returns issuers of all providers, deduplicated by canonical issuer keeping the first one.
+// other spellings of the first one are kept in its Aliases. errors of all providers are joined.
+func (provider *ChainIssuerProvider) Issuers(ctx context.Context) ([]Issuer, error) {
+	issuers := make([]Issuer, 0)
+	seen := make(map[string]int)
+	var errs []error
 
 	for _, p := range provider.providers {
-		issuers = append(issuers, p.Issuers()...)
+		provided, err := p.Issuers(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, issuer := range provided {
+			canonical := provider.normalizer.Canonical(issuer.URL)
+			if i, ok := seen[canonical]; ok {
+				issuers[i].Aliases = appendAlias(issuers[i], issuer.URL)
+				continue
+			}
+
+			seen[canonical] = len(issuers)
+			issuers = append(issuers, issuer)
+		}
+	}
+
+	return issuers, errors.Join(errs...)
+}
+
+// appendAlias returns aliases of issuer with alias, unless it is already a spelling of issuer
+func appendAlias(issuer Issuer, alias string) []string {
+	if alias == issuer.URL {
+		return issuer.Aliases
+	}
+	for _, existing := range issuer.Aliases {
+		if existing == alias {
+			return issuer.Aliases
+		}
 	}
 
-	return issuers
+	// copy, since issuers of providers may be cached and shared
+	return append(append(make([]string, 0, len(issuer.Aliases)+1), issuer.Aliases...), alias)
 }
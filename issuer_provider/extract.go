@@ -0,0 +1,269 @@
+package issuer_provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/jmespath/go-jmespath"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/util/jsonpath"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatCSV  = "csv"
+	// FormatText is one issuer per line. empty lines and lines starting with # are ignored.
+	FormatText = "text"
+
+	QueryLanguageGJson    = "gjson"
+	QueryLanguageJMESPath = "jmespath"
+	QueryLanguageJSONPath = "jsonpath"
+)
+
+// ExtractorConfig configures how issuers are extracted from a registry response.
+// the body is decoded by Format, then Query selects items. an item is either an issuer string
+// or an object whose IssuerField is the issuer and LabelsField and TTLField are its attributes.
+type ExtractorConfig struct {
+	Format        string `mapstructure:"format"`
+	QueryLanguage string `mapstructure:"queryLanguage"`
+	// Query selects items from the decoded document. empty selects the whole document.
+	Query string `mapstructure:"query"`
+
+	IssuerField string `mapstructure:"issuerField"`
+	LabelsField string `mapstructure:"labelsField"`
+	// TTLField is the key cache TTL of the issuer in seconds
+	TTLField string `mapstructure:"ttlField"`
+}
+
+func (config ExtractorConfig) withDefaults() ExtractorConfig {
+	if config.Format == "" {
+		config.Format = FormatJSON
+	}
+	if config.QueryLanguage == "" {
+		config.QueryLanguage = QueryLanguageGJson
+	}
+	if config.IssuerField == "" {
+		config.IssuerField = "issuer"
+	}
+	if config.LabelsField == "" {
+		config.LabelsField = "labels"
+	}
+	if config.TTLField == "" {
+		config.TTLField = "ttlSeconds"
+	}
+
+	return config
+}
+
+func (config ExtractorConfig) Validate() error {
+	config = config.withDefaults()
+
+	switch config.Format {
+	case FormatJSON, FormatYAML, FormatCSV, FormatText:
+	default:
+		return errors.Errorf("unknown format %s. must be one of %s, %s, %s, %s", config.Format, FormatJSON, FormatYAML, FormatCSV, FormatText)
+	}
+
+	switch config.QueryLanguage {
+	case QueryLanguageGJson:
+		// gjson has no syntax errors. an invalid path just matches nothing.
+	case QueryLanguageJMESPath:
+		if _, err := jmespath.Compile(config.Query); config.Query != "" && err != nil {
+			return errors.Wrapf(err, "invalid jmespath query %s", config.Query)
+		}
+	case QueryLanguageJSONPath:
+		if _, err := parseJSONPath(config.Query); config.Query != "" && err != nil {
+			return errors.Wrapf(err, "invalid jsonpath query %s", config.Query)
+		}
+	default:
+		return errors.Errorf("unknown query language %s. must be one of %s, %s, %s", config.QueryLanguage, QueryLanguageGJson, QueryLanguageJMESPath, QueryLanguageJSONPath)
+	}
+
+	return nil
+}
+
+// Extract extracts issuers from body. source is set as the source of every issuer.
+// items which are not issuers, e.g. numbers or null, are skipped rather than failing the whole response.
+func (config ExtractorConfig) Extract(ctx context.Context, body []byte, source string) ([]Issuer, error) {
+	config = config.withDefaults()
+
+	document, err := decodeDocument(config.Format, body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s document", config.Format)
+	}
+
+	selected, err := config.query(document, body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query %s", config.Query)
+	}
+
+	var items []interface{}
+	switch value := selected.(type) {
+	case nil:
+	case []interface{}:
+		items = value
+	default:
+		items = []interface{}{value}
+	}
+
+	issuers := make([]Issuer, 0, len(items))
+	for i, item := range items {
+		issuer, err := config.toIssuer(item)
+		if err != nil {
+			logging.FromContext(ctx).Warnf("skipping invalid item %d of %s: %v\n", i, source, err)
+			continue
+		}
+
+		issuer.Source = source
+		issuers = append(issuers, issuer)
+	}
+
+	return issuers, nil
+}
+
+func (config ExtractorConfig) query(document interface{}, body []byte) (interface{}, error) {
+	if config.Query == "" {
+		return document, nil
+	}
+
+	switch config.QueryLanguage {
+	case QueryLanguageJMESPath:
+		return jmespath.Search(config.Query, document)
+	case QueryLanguageJSONPath:
+		parser, err := parseJSONPath(config.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		results, err := parser.FindResults(document)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]interface{}, 0)
+		for _, result := range results {
+			for _, value := range result {
+				values = append(values, value.Interface())
+			}
+		}
+
+		return values, nil
+	default:
+		if config.Format != FormatJSON {
+			marshaled, err := json.Marshal(document)
+			if err != nil {
+				return nil, err
+			}
+			body = marshaled
+		}
+
+		return gjson.GetBytes(body, config.Query).Value(), nil
+	}
+}
+
+func (config ExtractorConfig) toIssuer(item interface{}) (Issuer, error) {
+	switch value := item.(type) {
+	case string:
+		return Issuer{URL: strings.TrimSpace(value)}, nil
+	case map[string]interface{}:
+		url, ok := value[config.IssuerField].(string)
+		if !ok || url == "" {
+			return Issuer{}, errors.Errorf("no string field %s", config.IssuerField)
+		}
+
+		issuer := Issuer{URL: strings.TrimSpace(url)}
+
+		if labels, ok := value[config.LabelsField].(map[string]interface{}); ok {
+			issuer.Labels = make(map[string]string, len(labels))
+			for key, label := range labels {
+				issuer.Labels[key] = fmt.Sprint(label)
+			}
+		}
+
+		if ttl, ok := value[config.TTLField]; ok {
+			seconds, err := strconv.ParseFloat(fmt.Sprint(ttl), 64)
+			if err != nil {
+				return Issuer{}, errors.Wrapf(err, "invalid %s", config.TTLField)
+			}
+			issuer.TTLSeconds = int(seconds)
+		}
+
+		return issuer, nil
+	default:
+		return Issuer{}, errors.Errorf("unsupported item type %T", item)
+	}
+}
+
+func decodeDocument(format string, body []byte) (interface{}, error) {
+	var document interface{}
+
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(body, &document); err != nil {
+			return nil, err
+		}
+	case FormatCSV:
+		// the first row is the header. every following row is an object keyed by the header.
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		rows := make([]interface{}, 0)
+		for i, record := range records {
+			if i == 0 {
+				continue
+			}
+
+			row := make(map[string]interface{}, len(record))
+			for j, value := range record {
+				row[records[0][j]] = value
+			}
+			rows = append(rows, row)
+		}
+		document = rows
+	case FormatText:
+		lines := make([]interface{}, 0)
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		document = lines
+	default:
+		if err := json.Unmarshal(body, &document); err != nil {
+			return nil, err
+		}
+	}
+
+	return document, nil
+}
+
+// parseJSONPath parses a kubectl style JSONPath expression. surrounding braces are optional.
+func parseJSONPath(query string) (*jsonpath.JSONPath, error) {
+	if !strings.HasPrefix(query, "{") {
+		query = "{" + query + "}"
+	}
+
+	parser := jsonpath.New("issuers").AllowMissingKeys(true)
+	if err := parser.Parse(query); err != nil {
+		return nil, err
+	}
+
+	return parser, nil
+}
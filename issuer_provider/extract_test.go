@@ -0,0 +1,33 @@
+package issuer_provider
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExtractMixedTypes(t *testing.T) {
+	body := []byte(`{"items":["https://a.example.com",1,true,null,{"issuer":"https://b.example.com"},{"name":"no issuer"},{"issuer":"https://c.example.com","ttlSeconds":"soon"},["nested"],"https://d.example.com"]}`)
+
+	for _, config := range []ExtractorConfig{
+		{QueryLanguage: QueryLanguageGJson, Query: "items"},
+		{QueryLanguage: QueryLanguageJMESPath, Query: "items"},
+		{QueryLanguage: QueryLanguageJSONPath, Query: ".items[*]"},
+	} {
+		t.Run(config.QueryLanguage, func(t *testing.T) {
+			issuers, err := config.Extract(context.Background(), body, "test")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := []string{"https://a.example.com", "https://b.example.com", "https://d.example.com"}
+			if len(issuers) != len(expected) {
+				t.Fatalf("expected %v, got %v", expected, issuers)
+			}
+			for i, issuer := range issuers {
+				if issuer.URL != expected[i] || issuer.Source != "test" {
+					t.Errorf("issuer %d: expected %s from test, got %s from %s", i, expected[i], issuer.URL, issuer.Source)
+				}
+			}
+		})
+	}
+}
@@ -1,17 +1,43 @@
 package issuer_provider
 
-import "github.com/spf13/viper"
+import (
+	"context"
+	"github.com/spf13/viper"
+	"strings"
+)
 
+// FileIssuerProvider provides issuers listed in `issuers` of config.
+// a single string value, e.g. of an environment variable, is split by commas. entries of a list are taken as is.
 type FileIssuerProvider struct {
+	name   string
 	config *viper.Viper
 }
 
-func NewFileIssuerProvider(config *viper.Viper) *FileIssuerProvider {
+// NewFileIssuerProvider creates a FileIssuerProvider. name is reported as the source of its issuers.
+func NewFileIssuerProvider(name string, config *viper.Viper) *FileIssuerProvider {
 	return &FileIssuerProvider{
+		name:   name,
 		config: config,
 	}
 }
 
-func (provider *FileIssuerProvider) Issuers() []string {
+func (provider *FileIssuerProvider) Issuers(ctx context.Context) ([]Issuer, error) {
+	issuers := make([]Issuer, 0)
+
+	for _, issuer := range provider.values() {
+		if issuer = strings.TrimSpace(issuer); issuer != "" {
+			issuers = append(issuers, Issuer{URL: issuer, Source: provider.name})
+		}
+	}
+
+	return issuers, nil
+}
+
+func (provider *FileIssuerProvider) values() []string {
+	// lists of config files and string slice flags are already split
+	if value, ok := provider.config.Get("issuers").(string); ok {
+		return strings.Split(value, ",")
+	}
+
 	return provider.config.GetStringSlice("issuers")
 }
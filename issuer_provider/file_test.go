@@ -0,0 +1,41 @@
+package issuer_provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestFileIssuerProviderSplitsStringOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected []string
+	}{
+		{"environment variable", "https://a.example.com, https://b.example.com", []string{"https://a.example.com", "https://b.example.com"}},
+		{"list", []interface{}{"https://a.example.com/x,y", "https://b.example.com"}, []string{"https://a.example.com/x,y", "https://b.example.com"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := viper.New()
+			config.Set("issuers", test.value)
+
+			issuers, err := NewFileIssuerProvider("test", config).Issuers(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			urls := IssuerURLs(issuers)
+			if len(urls) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, urls)
+			}
+			for i := range urls {
+				if urls[i] != test.expected[i] {
+					t.Errorf("expected %v, got %v", test.expected, urls)
+				}
+			}
+		})
+	}
+}
@@ -1,81 +1,124 @@
 package issuer_provider
 
 import (
-	"github.com/krafton-hq/oidc-discovery-server/util/perf"
+	"context"
+	stderrors "errors"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
-	"github.com/tidwall/gjson"
 	"go.uber.org/zap"
-	"io"
-	"net/http"
+	"sync"
 )
 
+// HTTPIssuerProvider queries issuers from one or more HTTP endpoints.
+// issuers of every endpoint are cached independently, then merged and deduplicated.
 type HTTPIssuerProvider struct {
-	config *viper.Viper
+	config    *viper.Viper
+	endpoints []*httpIssuerEndpoint
 }
 
 func NewHTTPIssuerProvider(config *viper.Viper) *HTTPIssuerProvider {
-	return &HTTPIssuerProvider{
+	config.SetDefault("maxTTLSeconds", 60)
+	config.SetDefault("retryIntervalSeconds", 10)
+	config.SetDefault("timeoutSeconds", 30)
+
+	provider := &HTTPIssuerProvider{
 		config: config,
 	}
-}
 
-func (provider *HTTPIssuerProvider) Issuers() []string {
-	issuers, err := provider.queryIssuers()
+	endpointConfigs, err := provider.EndpointConfigs()
 	if err != nil {
-		zap.S().Errorf("error while querying issuers: %v", err)
-		return []string{}
+		zap.S().Errorf("failed to parse http issuer provider endpoints. %v", err)
 	}
 
-	return issuers
-}
+	for _, endpointConfig := range endpointConfigs {
+		endpoint, err := newHTTPIssuerEndpoint(endpointConfig)
+		if err != nil {
+			zap.S().Errorf("skipping http issuer provider endpoint. %v", err)
+			continue
+		}
 
-func (provider *HTTPIssuerProvider) queryIssuers() ([]string, error) {
-	defer perf.Perf("queryIssuers")()
-	body, err := provider.queryEndpoint()
-	if err != nil {
-		return nil, errors.Wrap(err, "error while querying getting issuers")
+		provider.endpoints = append(provider.endpoints, endpoint)
 	}
 
-	gjsonQuery := provider.GJsonQuery()
-	zap.S().Debugf("body: %s, gjsonQuery: %s\n", body, gjsonQuery)
-	res := gjson.Get(body, gjsonQuery).Array()
+	return provider
+}
+
+// Issuers returns issuers of all endpoints, deduplicated by URL. errors of failing endpoints are joined.
+func (provider *HTTPIssuerProvider) Issuers(ctx context.Context) ([]Issuer, error) {
+	results := make([][]Issuer, len(provider.endpoints))
+	errs := make([]error, len(provider.endpoints))
 
-	issuers := make([]string, 0)
-	for _, value := range res {
-		issuers = append(issuers, value.String())
+	var wg sync.WaitGroup
+	for i, endpoint := range provider.endpoints {
+		i, endpoint := i, endpoint
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = endpoint.Issuers(ctx)
+		}()
+	}
+	wg.Wait()
+
+	issuers := make([]Issuer, 0)
+	seen := make(map[string]struct{})
+	for _, result := range results {
+		for _, issuer := range result {
+			if _, ok := seen[issuer.URL]; ok {
+				continue
+			}
+
+			seen[issuer.URL] = struct{}{}
+			issuers = append(issuers, issuer)
+		}
 	}
 
-	return issuers, nil
+	return issuers, stderrors.Join(errs...)
 }
 
-func (provider *HTTPIssuerProvider) queryEndpoint() (string, error) {
-	defer perf.Perf("queryEndpoint")()
-	endpoint := provider.Endpoint()
+// EndpointConfigs returns configs of all endpoints. the legacy single `endpoint` is treated as the first endpoint.
+// unset TTLs of each endpoint are inherited from the provider.
+func (provider *HTTPIssuerProvider) EndpointConfigs() ([]HTTPEndpointConfig, error) {
+	configs := make([]HTTPEndpointConfig, 0)
 
-	defer perf.Perf("queryEndpoint.http.Get")()
-	res, err := http.Get(endpoint)
-	if err != nil {
-		return "", errors.Wrapf(err, "error while fetching issuers from endpoint: %s", endpoint)
+	if endpoint := provider.config.GetString("endpoint"); endpoint != "" {
+		configs = append(configs, HTTPEndpointConfig{
+			Endpoint:   endpoint,
+			GJsonQuery: provider.config.GetString("gjsonQuery"),
+		})
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return "", errors.Wrap(err, "error while reading response body")
+	var endpoints []HTTPEndpointConfig
+	if err := provider.config.UnmarshalKey("endpoints", &endpoints); err != nil {
+		return configs, errors.Wrap(err, "failed to unmarshal endpoints")
+	}
+	configs = append(configs, endpoints...)
+
+	for i := range configs {
+		if configs[i].MaxTTLSeconds <= 0 {
+			configs[i].MaxTTLSeconds = provider.MaxTTLSeconds()
+		}
+		if configs[i].RetryIntervalSeconds <= 0 {
+			configs[i].RetryIntervalSeconds = provider.RetryIntervalSeconds()
+		}
+		if configs[i].TimeoutSeconds <= 0 {
+			configs[i].TimeoutSeconds = provider.TimeoutSeconds()
+		}
 	}
 
-	return string(body), nil
+	return configs, nil
 }
 
-// TODO: support multiple endpoints?
-func (provider *HTTPIssuerProvider) Endpoint() string {
-	return provider.config.GetString("endpoint")
+func (provider *HTTPIssuerProvider) MaxTTLSeconds() int {
+	return provider.config.GetInt("maxTTLSeconds")
 }
 
-func (provider *HTTPIssuerProvider) GJsonQuery() string {
-	return provider.config.GetString("gjsonQuery")
+// RetryIntervalSeconds is the interval between queries while an endpoint is failing
+func (provider *HTTPIssuerProvider) RetryIntervalSeconds() int {
+	return provider.config.GetInt("retryIntervalSeconds")
 }
 
-func (provider *HTTPIssuerProvider) MaxTTLSeconds() int {
-	return provider.config.GetInt("maxTTLSeconds")
+// TimeoutSeconds bounds a single query of an endpoint, including all of its pages
+func (provider *HTTPIssuerProvider) TimeoutSeconds() int {
+	return provider.config.GetInt("timeoutSeconds")
 }
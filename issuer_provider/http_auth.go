@@ -0,0 +1,229 @@
+package issuer_provider
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPAuthConfig configures credentials sent to an issuer registry.
+// at most one of bearer token, basic auth and oauth2 may be set.
+type HTTPAuthConfig struct {
+	BearerToken string `mapstructure:"bearerToken"`
+	// BearerTokenFile is re-read whenever it changes, e.g. projected service account tokens
+	BearerTokenFile string `mapstructure:"bearerTokenFile"`
+
+	Basic  *HTTPBasicAuthConfig  `mapstructure:"basic"`
+	OAuth2 *HTTPOAuth2AuthConfig `mapstructure:"oauth2"`
+}
+
+type HTTPBasicAuthConfig struct {
+	Username     string `mapstructure:"username"`
+	Password     string `mapstructure:"password"`
+	PasswordFile string `mapstructure:"passwordFile"`
+}
+
+// HTTPOAuth2AuthConfig configures the OAuth 2.0 client credentials grant
+type HTTPOAuth2AuthConfig struct {
+	TokenURL         string            `mapstructure:"tokenURL"`
+	ClientID         string            `mapstructure:"clientID"`
+	ClientSecret     string            `mapstructure:"clientSecret"`
+	ClientSecretFile string            `mapstructure:"clientSecretFile"`
+	Scopes           []string          `mapstructure:"scopes"`
+	EndpointParams   map[string]string `mapstructure:"endpointParams"`
+}
+
+func (config *HTTPAuthConfig) Validate() error {
+	methods := 0
+	if config.BearerToken != "" || config.BearerTokenFile != "" {
+		methods++
+	}
+	if config.BearerToken != "" && config.BearerTokenFile != "" {
+		return errors.New("only one of bearerToken and bearerTokenFile can be set")
+	}
+	if config.Basic != nil {
+		methods++
+		if config.Basic.Password != "" && config.Basic.PasswordFile != "" {
+			return errors.New("only one of basic.password and basic.passwordFile can be set")
+		}
+	}
+	if config.OAuth2 != nil {
+		methods++
+		if config.OAuth2.TokenURL == "" || config.OAuth2.ClientID == "" {
+			return errors.New("oauth2.tokenURL and oauth2.clientID are required")
+		}
+		if config.OAuth2.ClientSecret != "" && config.OAuth2.ClientSecretFile != "" {
+			return errors.New("only one of oauth2.clientSecret and oauth2.clientSecretFile can be set")
+		}
+	}
+
+	if methods > 1 {
+		return errors.New("only one of bearer token, basic and oauth2 auth can be set")
+	}
+
+	return nil
+}
+
+// Client returns an http client which authenticates every request
+func (config *HTTPAuthConfig) Client() (*http.Client, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.OAuth2 != nil:
+		secret := config.OAuth2.ClientSecret
+		if file := config.OAuth2.ClientSecretFile; file != "" {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read client secret file %s", file)
+			}
+			secret = strings.TrimSpace(string(content))
+		}
+
+		params := make(map[string][]string, len(config.OAuth2.EndpointParams))
+		for key, value := range config.OAuth2.EndpointParams {
+			params[key] = []string{value}
+		}
+
+		credentials := &clientcredentials.Config{
+			ClientID:       config.OAuth2.ClientID,
+			ClientSecret:   secret,
+			TokenURL:       config.OAuth2.TokenURL,
+			Scopes:         config.OAuth2.Scopes,
+			EndpointParams: params,
+		}
+
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, http.DefaultClient)
+		client := credentials.Client(ctx)
+		client.CheckRedirect = sameOriginRedirect
+		return client, nil
+	case config.BearerToken != "":
+		token := config.BearerToken
+		return authClient(func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}), nil
+	case config.BearerTokenFile != "":
+		token := newFileSecret(config.BearerTokenFile)
+		return authClient(func(req *http.Request) error {
+			value, err := token.Get()
+			if err != nil {
+				return err
+			}
+
+			req.Header.Set("Authorization", "Bearer "+value)
+			return nil
+		}), nil
+	case config.Basic != nil:
+		username := config.Basic.Username
+		password := newFileSecret(config.Basic.PasswordFile)
+		if config.Basic.PasswordFile == "" {
+			password = staticSecret(config.Basic.Password)
+		}
+
+		return authClient(func(req *http.Request) error {
+			value, err := password.Get()
+			if err != nil {
+				return err
+			}
+
+			req.SetBasicAuth(username, value)
+			return nil
+		}), nil
+	default:
+		return http.DefaultClient, nil
+	}
+}
+
+func authClient(authenticate func(req *http.Request) error) *http.Client {
+	return &http.Client{
+		Transport: &authTransport{
+			base:         http.DefaultTransport,
+			authenticate: authenticate,
+		},
+		CheckRedirect: sameOriginRedirect,
+	}
+}
+
+// sameOriginRedirect refuses redirects to another scheme or host. credentials are added by the transport to every request,
+// including redirected ones, so net/http can't strip them on cross-host redirects as it does for headers of the initial request.
+func sameOriginRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	if origin := via[0].URL; req.URL.Scheme != origin.Scheme || req.URL.Host != origin.Host {
+		return errors.Errorf("refusing to follow redirect from %s://%s to %s://%s with credentials", origin.Scheme, origin.Host, req.URL.Scheme, req.URL.Host)
+	}
+
+	return nil
+}
+
+type authTransport struct {
+	base         http.RoundTripper
+	authenticate func(req *http.Request) error
+}
+
+func (transport *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the request
+	req = req.Clone(req.Context())
+	if err := transport.authenticate(req); err != nil {
+		return nil, errors.Wrap(err, "failed to authenticate request")
+	}
+
+	return transport.base.RoundTrip(req)
+}
+
+// fileSecret reads a secret from a file, re-reading it only when the file changes
+type fileSecret struct {
+	path string
+
+	lock    sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+func newFileSecret(path string) *fileSecret {
+	return &fileSecret{path: path}
+}
+
+func staticSecret(value string) *fileSecret {
+	return &fileSecret{value: value}
+}
+
+func (secret *fileSecret) Get() (string, error) {
+	if secret.path == "" {
+		return secret.value, nil
+	}
+
+	secret.lock.Lock()
+	defer secret.lock.Unlock()
+
+	info, err := os.Stat(secret.path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to stat secret file %s", secret.path)
+	}
+
+	if info.ModTime().Equal(secret.modTime) && info.Size() == secret.size {
+		return secret.value, nil
+	}
+
+	content, err := os.ReadFile(secret.path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read secret file %s", secret.path)
+	}
+
+	secret.value = strings.TrimSpace(string(content))
+	secret.modTime = info.ModTime()
+	secret.size = info.Size()
+
+	return secret.value, nil
+}
@@ -0,0 +1,253 @@
+package issuer_provider
+
+import (
+	"context"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
+	"github.com/krafton-hq/oidc-discovery-server/util/perf"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type HTTPEndpointConfig struct {
+	Endpoint string `mapstructure:"endpoint"`
+	// GJsonQuery is the legacy query. it is used when Query is not set.
+	GJsonQuery string            `mapstructure:"gjsonQuery"`
+	Headers    map[string]string `mapstructure:"headers"`
+	Auth       HTTPAuthConfig    `mapstructure:"auth"`
+
+	Extractor  ExtractorConfig  `mapstructure:",squash"`
+	Pagination PaginationConfig `mapstructure:"pagination"`
+
+	MaxTTLSeconds        int `mapstructure:"maxTTLSeconds"`
+	RetryIntervalSeconds int `mapstructure:"retryIntervalSeconds"`
+	TimeoutSeconds       int `mapstructure:"timeoutSeconds"`
+}
+
+// httpIssuerEndpoint caches issuers of a single endpoint for MaxTTLSeconds and refreshes them in background after that.
+// on failure, the last known good issuers are kept.
+type httpIssuerEndpoint struct {
+	config HTTPEndpointConfig
+	client *http.Client
+
+	lock    sync.RWMutex
+	issuers []Issuer
+	fetched bool
+	expires time.Time
+	lastErr error
+
+	// refreshLock serializes queries to the endpoint
+	refreshLock sync.Mutex
+	refreshing  atomic.Bool
+}
+
+// withDefaults applies the legacy gjsonQuery to the extractor
+func (config HTTPEndpointConfig) withDefaults() HTTPEndpointConfig {
+	if config.Extractor.Query == "" && config.GJsonQuery != "" {
+		config.Extractor.Query = config.GJsonQuery
+		config.Extractor.QueryLanguage = QueryLanguageGJson
+	}
+
+	return config
+}
+
+func (config HTTPEndpointConfig) Validate() error {
+	config = config.withDefaults()
+
+	parsed, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return errors.Wrap(err, "invalid endpoint")
+	}
+	if config.Endpoint == "" || parsed.Host == "" {
+		return errors.Errorf("endpoint %q must be an absolute URL", config.Endpoint)
+	}
+
+	if err := config.Auth.Validate(); err != nil {
+		return errors.Wrap(err, "invalid auth")
+	}
+	if err := config.Extractor.Validate(); err != nil {
+		return errors.Wrap(err, "invalid extractor")
+	}
+	if err := config.Pagination.Validate(); err != nil {
+		return errors.Wrap(err, "invalid pagination")
+	}
+
+	if format := config.Extractor.withDefaults().Format; config.Extractor.Query == "" && (format == FormatJSON || format == FormatYAML) {
+		return errors.Errorf("query or gjsonQuery is required for %s format. use @this to select the whole document", format)
+	}
+
+	return nil
+}
+
+func newHTTPIssuerEndpoint(config HTTPEndpointConfig) (*httpIssuerEndpoint, error) {
+	config = config.withDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid config of endpoint %s", config.Endpoint)
+	}
+
+	client, err := config.Auth.Client()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid auth config of endpoint %s", config.Endpoint)
+	}
+
+	return &httpIssuerEndpoint{
+		config: config,
+		client: client,
+	}, nil
+}
+
+// Issuers returns cached issuers and the error of the last query, if it failed.
+// ctx bounds only the first query, which is waited for. later queries run in background.
+func (endpoint *httpIssuerEndpoint) Issuers(ctx context.Context) ([]Issuer, error) {
+	endpoint.lock.RLock()
+	issuers, fetched, expires, lastErr := endpoint.issuers, endpoint.fetched, endpoint.expires, endpoint.lastErr
+	endpoint.lock.RUnlock()
+
+	if time.Now().After(expires) {
+		if !fetched {
+			// nothing to serve yet. wait for the query.
+			return endpoint.refresh(ctx)
+		}
+
+		endpoint.refreshInBackground()
+	}
+
+	return issuers, lastErr
+}
+
+func (endpoint *httpIssuerEndpoint) refreshInBackground() {
+	if !endpoint.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer endpoint.refreshing.Store(false)
+		endpoint.refresh(context.Background())
+	}()
+}
+
+// refresh queries issuers and updates the cache. returns the cached issuers and the error of the query after refresh.
+// a query canceled by ctx is not recorded as a failure, since it says nothing about the endpoint.
+func (endpoint *httpIssuerEndpoint) refresh(ctx context.Context) ([]Issuer, error) {
+	endpoint.refreshLock.Lock()
+	defer endpoint.refreshLock.Unlock()
+
+	endpoint.lock.RLock()
+	if time.Now().Before(endpoint.expires) {
+		// refreshed or failed by another goroutine while waiting for refreshLock
+		defer endpoint.lock.RUnlock()
+		return endpoint.issuers, endpoint.lastErr
+	}
+	endpoint.lock.RUnlock()
+
+	issuers, err := endpoint.queryIssuers(ctx)
+
+	endpoint.lock.Lock()
+	defer endpoint.lock.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		return endpoint.issuers, errors.Wrapf(err, "endpoint %s", endpoint.config.Endpoint)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Errorf("error while querying issuers from %s. keeping %d last known issuers: %v", endpoint.config.Endpoint, len(endpoint.issuers), err)
+		endpoint.expires = time.Now().Add(time.Duration(endpoint.config.RetryIntervalSeconds) * time.Second)
+		endpoint.lastErr = errors.Wrapf(err, "endpoint %s", endpoint.config.Endpoint)
+		return endpoint.issuers, endpoint.lastErr
+	}
+
+	endpoint.issuers = issuers
+	endpoint.lastErr = nil
+	endpoint.fetched = true
+	endpoint.expires = time.Now().Add(time.Duration(endpoint.config.MaxTTLSeconds) * time.Second)
+	logging.FromContext(ctx).Debugf("issuers refreshed. endpoint: %s, count: %d, expires: %s\n", endpoint.config.Endpoint, len(issuers), endpoint.expires)
+
+	return issuers, nil
+}
+
+func (endpoint *httpIssuerEndpoint) queryIssuers(ctx context.Context) ([]Issuer, error) {
+	defer perf.Perf("queryIssuers")()
+
+	if endpoint.config.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(endpoint.config.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	pagination := endpoint.config.Pagination.withDefaults()
+	extractor := endpoint.config.Extractor
+
+	url, err := pagination.firstPage(endpoint.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	issuers := make([]Issuer, 0)
+	visited := make(map[string]struct{})
+	for page := 0; url != ""; page++ {
+		// a truncated list would silently distrust issuers of further pages, so fail and keep the last known issuers
+		if page >= pagination.MaxPages {
+			return nil, errors.Errorf("endpoint has more than %d pages. raise pagination.maxPages", pagination.MaxPages)
+		}
+
+		if _, ok := visited[url]; ok {
+			return nil, errors.Errorf("pagination loop at %s", url)
+		}
+		visited[url] = struct{}{}
+
+		body, header, err := endpoint.queryEndpoint(ctx, url)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while querying getting issuers")
+		}
+
+		logging.FromContext(ctx).Debugf("body: %s, query: %s\n", body, extractor.Query)
+
+		pageIssuers, err := extractor.Extract(ctx, []byte(body), endpoint.config.Endpoint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error while extracting issuers from %s", url)
+		}
+		issuers = append(issuers, pageIssuers...)
+
+		next, err := pagination.nextPage(url, header, body, len(pageIssuers))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error while getting next page of %s", url)
+		}
+		url = next
+	}
+
+	return issuers, nil
+}
+
+func (endpoint *httpIssuerEndpoint) queryEndpoint(ctx context.Context, url string) (string, http.Header, error) {
+	defer perf.Perf("queryEndpoint")()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error while creating request to endpoint: %s", url)
+	}
+
+	for key, value := range endpoint.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	defer perf.Perf("queryEndpoint.http.Do")()
+	res, err := endpoint.client.Do(req)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error while fetching issuers from endpoint: %s", url)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", nil, errors.Errorf("unexpected status code %d from endpoint: %s", res.StatusCode, url)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "error while reading response body")
+	}
+
+	return string(body), res.Header, nil
+}
@@ -0,0 +1,51 @@
+package issuer_provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTPEndpointMaxPagesKeepsLastKnownIssuers(t *testing.T) {
+	var pages atomic.Int32
+	pages.Store(2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		next := ""
+		if page+1 < int(pages.Load()) {
+			next = strconv.Itoa(page + 1)
+		}
+
+		fmt.Fprintf(w, `{"items":["https://issuer-%d.example.com"],"next":%q}`, page, next)
+	}))
+	defer server.Close()
+
+	endpoint, err := newHTTPIssuerEndpoint(HTTPEndpointConfig{
+		Endpoint:   server.URL,
+		GJsonQuery: "items",
+		Pagination: PaginationConfig{Type: PaginationCursor, CursorQuery: "next", MaxPages: 2},
+	})
+	if err != nil {
+		t.Fatalf("failed to create endpoint: %v", err)
+	}
+
+	issuers, err := endpoint.refresh(context.Background())
+	if err != nil || len(issuers) != 2 {
+		t.Fatalf("expected 2 issuers, got %v: %v", issuers, err)
+	}
+
+	// the endpoint grows past maxPages. maxTTLSeconds is 0, so the next refresh queries again.
+	pages.Store(3)
+	issuers, err = endpoint.refresh(context.Background())
+	if err == nil {
+		t.Errorf("expected an error when the endpoint has more than maxPages pages")
+	}
+	if len(issuers) != 2 {
+		t.Errorf("expected the last known 2 issuers, got %v", issuers)
+	}
+}
@@ -0,0 +1,205 @@
+package issuer_provider
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PaginationLink follows the rel="next" URL of the Link header (RFC 8288)
+	PaginationLink = "link"
+	// PaginationCursor reads the next cursor from the response body and sends it as a query parameter
+	PaginationCursor = "cursor"
+	// PaginationOffset increments an offset query parameter by limit until a page returns less than limit issuers
+	PaginationOffset = "offset"
+
+	defaultMaxPages = 100
+)
+
+// PaginationConfig configures how pages of an endpoint are followed. empty Type performs a single request.
+type PaginationConfig struct {
+	Type string `mapstructure:"type"`
+	// MaxPages caps the number of requests per refresh. a query reaching the cap fails, keeping the last known issuers.
+	MaxPages int `mapstructure:"maxPages"`
+
+	// CursorQuery is a gjson query of the next cursor in the response body, e.g. nextToken. empty or missing cursor ends pagination.
+	CursorQuery string `mapstructure:"cursorQuery"`
+	// CursorParam is the query parameter the cursor is sent with
+	CursorParam string `mapstructure:"cursorParam"`
+
+	OffsetParam string `mapstructure:"offsetParam"`
+	LimitParam  string `mapstructure:"limitParam"`
+	Limit       int    `mapstructure:"limit"`
+}
+
+func (config PaginationConfig) withDefaults() PaginationConfig {
+	if config.MaxPages <= 0 {
+		config.MaxPages = defaultMaxPages
+	}
+	if config.CursorParam == "" {
+		config.CursorParam = "cursor"
+	}
+	if config.OffsetParam == "" {
+		config.OffsetParam = "offset"
+	}
+	if config.LimitParam == "" {
+		config.LimitParam = "limit"
+	}
+	if config.Limit <= 0 {
+		config.Limit = 100
+	}
+
+	return config
+}
+
+func (config PaginationConfig) Validate() error {
+	switch config.Type {
+	case "", PaginationLink, PaginationOffset:
+	case PaginationCursor:
+		if config.CursorQuery == "" {
+			return errors.New("cursorQuery is required for cursor pagination")
+		}
+	default:
+		return errors.Errorf("unknown pagination type %s. must be one of %s, %s, %s", config.Type, PaginationLink, PaginationCursor, PaginationOffset)
+	}
+
+	return nil
+}
+
+// firstPage returns the URL of the first page
+func (config PaginationConfig) firstPage(endpoint string) (string, error) {
+	config = config.withDefaults()
+	if config.Type != PaginationOffset {
+		return endpoint, nil
+	}
+
+	return withQuery(endpoint, map[string]string{
+		config.OffsetParam: "0",
+		config.LimitParam:  strconv.Itoa(config.Limit),
+	})
+}
+
+// nextPage returns the URL of the page after current, or empty if current is the last page.
+// count is the number of issuers extracted from the current page.
+// pages are fetched with headers and credentials of the endpoint, so the next page must be on the same scheme and host as current.
+func (config PaginationConfig) nextPage(current string, header http.Header, body string, count int) (string, error) {
+	next, err := config.nextPageURL(current, header, body, count)
+	if err != nil || next == "" {
+		return next, err
+	}
+
+	if err := checkSameOrigin(current, next); err != nil {
+		return "", err
+	}
+
+	return next, nil
+}
+
+func (config PaginationConfig) nextPageURL(current string, header http.Header, body string, count int) (string, error) {
+	config = config.withDefaults()
+
+	switch config.Type {
+	case PaginationLink:
+		next := nextLink(header)
+		if next == "" {
+			return "", nil
+		}
+
+		base, err := url.Parse(current)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(next)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid next link %s", next)
+		}
+
+		return base.ResolveReference(ref).String(), nil
+	case PaginationCursor:
+		cursor := gjson.Get(body, config.CursorQuery).String()
+		if cursor == "" {
+			return "", nil
+		}
+
+		return withQuery(current, map[string]string{config.CursorParam: cursor})
+	case PaginationOffset:
+		if count < config.Limit {
+			return "", nil
+		}
+
+		parsed, err := url.Parse(current)
+		if err != nil {
+			return "", err
+		}
+		offset, _ := strconv.Atoi(parsed.Query().Get(config.OffsetParam))
+
+		return withQuery(current, map[string]string{config.OffsetParam: strconv.Itoa(offset + config.Limit)})
+	default:
+		return "", nil
+	}
+}
+
+// checkSameOrigin returns an error if next has another scheme or host than current
+func checkSameOrigin(current, next string) error {
+	currentURL, err := url.Parse(current)
+	if err != nil {
+		return err
+	}
+	nextURL, err := url.Parse(next)
+	if err != nil {
+		return errors.Wrapf(err, "invalid next page %s", next)
+	}
+
+	if !strings.EqualFold(currentURL.Scheme, nextURL.Scheme) || !strings.EqualFold(currentURL.Host, nextURL.Host) {
+		return errors.Errorf("refusing to follow next page %s on another origin than %s://%s", next, currentURL.Scheme, currentURL.Host)
+	}
+
+	return nil
+}
+
+// nextLink returns the target of rel="next" in Link headers
+func nextLink(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+
+			for _, param := range parts[1:] {
+				key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+					}
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func withQuery(rawURL string, params map[string]string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid url %s", rawURL)
+	}
+
+	query := parsed.Query()
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
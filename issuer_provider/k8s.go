@@ -0,0 +1,188 @@
+package issuer_provider
+
+import (
+	"context"
+	"github.com/krafton-hq/oidc-discovery-server/jwt"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"time"
+)
+
+// TrustedIssuerResource is the TrustedIssuer custom resource. see deploy/crds/trustedissuers.yaml
+var TrustedIssuerResource = schema.GroupVersionResource{
+	Group:    "oidc-discovery.krafton.com",
+	Version:  "v1alpha1",
+	Resource: "trustedissuers",
+}
+
+// KeySetStatusFunc returns status of the key set of the issuer, false if the issuer has not been fetched yet
+type KeySetStatusFunc func(issuer string) (jwt.KeySetStatus, bool)
+
+// K8SIssuerProvider provides issuers from TrustedIssuer custom resources watched with an informer.
+// TODO: out-cluster support?
+type K8SIssuerProvider struct {
+	config   *viper.Viper
+	client   dynamic.Interface
+	informer cache.SharedIndexInformer
+	stopCh   chan struct{}
+}
+
+func NewK8SIssuerProvider(config *viper.Viper) (*K8SIssuerProvider, error) {
+	config.SetDefault("resyncSeconds", 600)
+	config.SetDefault("syncTimeoutSeconds", 30)
+	config.SetDefault("statusIntervalSeconds", 30)
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while getting in-cluster config")
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &K8SIssuerProvider{
+		config: config,
+		client: client,
+		stopCh: make(chan struct{}),
+	}
+
+	labelSelector := provider.LabelSelector()
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+		client,
+		time.Duration(config.GetInt("resyncSeconds"))*time.Second,
+		provider.Namespace(),
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = labelSelector
+		},
+	)
+	provider.informer = factory.ForResource(TrustedIssuerResource).Informer()
+
+	go provider.informer.Run(provider.stopCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.GetInt("syncTimeoutSeconds"))*time.Second)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), provider.informer.HasSynced) {
+		provider.Close()
+		return nil, errors.Errorf("timed out waiting for %s informer to sync", TrustedIssuerResource.Resource)
+	}
+
+	return provider, nil
+}
+
+func (provider *K8SIssuerProvider) Issuers(ctx context.Context) ([]Issuer, error) {
+	if !provider.informer.HasSynced() {
+		return nil, errors.Errorf("%s informer has not synced", TrustedIssuerResource.Resource)
+	}
+
+	issuers := make([]Issuer, 0)
+
+	for _, obj := range provider.informer.GetStore().List() {
+		resource, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		issuer, found, err := unstructured.NestedString(resource.Object, "spec", "issuer")
+		if err != nil || !found || issuer == "" {
+			logging.FromContext(ctx).Warnf("skipping %s %s/%s without spec.issuer", resource.GetKind(), resource.GetNamespace(), resource.GetName())
+			continue
+		}
+
+		issuers = append(issuers, Issuer{
+			URL:    issuer,
+			Source: "k8s:" + resource.GetNamespace() + "/" + resource.GetName(),
+			Labels: resource.GetLabels(),
+		})
+	}
+
+	return issuers, nil
+}
+
+// ReportStatus periodically writes key set status of every TrustedIssuer into its status subresource until ctx is done
+func (provider *K8SIssuerProvider) ReportStatus(ctx context.Context, keySetStatus KeySetStatusFunc) {
+	ticker := time.NewTicker(time.Duration(provider.StatusIntervalSeconds()) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			provider.reportStatus(ctx, keySetStatus)
+		}
+	}
+}
+
+func (provider *K8SIssuerProvider) reportStatus(ctx context.Context, keySetStatus KeySetStatusFunc) {
+	for _, obj := range provider.informer.GetStore().List() {
+		resource, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		issuer, _, _ := unstructured.NestedString(resource.Object, "spec", "issuer")
+		status, ok := keySetStatus(issuer)
+		if !ok {
+			continue
+		}
+
+		newStatus := map[string]interface{}{
+			"observedGeneration":  resource.GetGeneration(),
+			"healthy":             status.ConsecutiveFailures == 0 && status.KeyCount > 0,
+			"keyCount":            int64(status.KeyCount),
+			"circuit":             status.Circuit.String(),
+			"consecutiveFailures": int64(status.ConsecutiveFailures),
+			"jwksUri":             status.JwksURI,
+			"lastError":           status.LastError,
+		}
+		if status.LastSuccess != nil {
+			newStatus["lastSuccessTime"] = status.LastSuccess.UTC().Format(time.RFC3339)
+		}
+
+		oldStatus, _, _ := unstructured.NestedMap(resource.Object, "status")
+		if equality.Semantic.DeepEqual(oldStatus, newStatus) {
+			continue
+		}
+
+		updated := resource.DeepCopy()
+		if err := unstructured.SetNestedMap(updated.Object, newStatus, "status"); err != nil {
+			zap.S().Errorf("failed to set status of %s/%s. %v", resource.GetNamespace(), resource.GetName(), err)
+			continue
+		}
+
+		_, err := provider.client.Resource(TrustedIssuerResource).Namespace(resource.GetNamespace()).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+		if err != nil {
+			zap.S().Warnf("failed to update status of %s/%s. %v", resource.GetNamespace(), resource.GetName(), err)
+		}
+	}
+}
+
+// Close stops the informer
+func (provider *K8SIssuerProvider) Close() {
+	close(provider.stopCh)
+}
+
+// Namespace to watch. empty for all namespaces
+func (provider *K8SIssuerProvider) Namespace() string {
+	return provider.config.GetString("namespace")
+}
+
+func (provider *K8SIssuerProvider) LabelSelector() string {
+	return provider.config.GetString("labelSelector")
+}
+
+func (provider *K8SIssuerProvider) StatusIntervalSeconds() int {
+	return provider.config.GetInt("statusIntervalSeconds")
+}
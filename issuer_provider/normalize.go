@@ -0,0 +1,80 @@
+package issuer_provider
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	// TrailingSlashStrip treats https://a.example.com/ and https://a.example.com as the same issuer
+	TrailingSlashStrip = "strip"
+	// TrailingSlashPreserve treats them as different issuers
+	TrailingSlashPreserve = "preserve"
+)
+
+// IssuerNormalizer canonicalizes issuer URLs, so that the same issuer listed in different forms is fetched only once.
+// the canonical form is only used as a key. the first exact issuer string is still used for fetching,
+// and every exact issuer string of the same canonical issuer is accepted as the issuer of its discovery document.
+type IssuerNormalizer struct {
+	TrailingSlash string `mapstructure:"trailingSlash"`
+}
+
+func NewIssuerNormalizer(config *viper.Viper) (IssuerNormalizer, error) {
+	normalizer := IssuerNormalizer{TrailingSlash: TrailingSlashStrip}
+	if config == nil {
+		return normalizer, nil
+	}
+
+	if err := config.Unmarshal(&normalizer); err != nil {
+		return normalizer, errors.Wrap(err, "failed to unmarshal issuer normalization")
+	}
+
+	if normalizer.TrailingSlash == "" {
+		normalizer.TrailingSlash = TrailingSlashStrip
+	}
+
+	return normalizer, normalizer.Validate()
+}
+
+func (normalizer IssuerNormalizer) Validate() error {
+	switch normalizer.TrailingSlash {
+	case "", TrailingSlashStrip, TrailingSlashPreserve:
+		return nil
+	default:
+		return errors.Errorf("unknown trailingSlash %s. must be one of %s, %s", normalizer.TrailingSlash, TrailingSlashStrip, TrailingSlashPreserve)
+	}
+}
+
+// Canonical lowercases scheme and host, removes default ports and applies the trailing slash policy.
+// an issuer which is not a valid URL is returned as is.
+func (normalizer IssuerNormalizer) Canonical(issuer string) string {
+	parsed, err := url.Parse(strings.TrimSpace(issuer))
+	if err != nil || parsed.Host == "" {
+		return issuer
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+
+	host := strings.ToLower(parsed.Hostname())
+	port := parsed.Port()
+	if (parsed.Scheme == "https" && port == "443") || (parsed.Scheme == "http" && port == "80") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 literal
+		host = "[" + host + "]"
+	}
+	parsed.Host = host
+
+	if normalizer.TrailingSlash != TrailingSlashPreserve {
+		parsed.Path = strings.TrimRight(parsed.Path, "/")
+		parsed.RawPath = strings.TrimRight(parsed.RawPath, "/")
+	}
+
+	return parsed.String()
+}
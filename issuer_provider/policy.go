@@ -0,0 +1,253 @@
+package issuer_provider
+
+import (
+	"context"
+	"fmt"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IssuerPolicyConfig decides which issuers are trusted.
+// denied patterns take precedence. if any allow rule is set, issuers must match at least one of them.
+// patterns and regexes are matched against both the issuer and its canonical form.
+type IssuerPolicyConfig struct {
+	RequireHTTPS bool `mapstructure:"requireHTTPS"`
+	// AllowedHosts are exact hosts, e.g. token.actions.githubusercontent.com
+	AllowedHosts []string `mapstructure:"allowedHosts"`
+	// AllowedDomains allow the domain and all its subdomains, e.g. amazonaws.com
+	AllowedDomains []string `mapstructure:"allowedDomains"`
+	// AllowedPatterns are globs over the whole issuer URL where * matches anything but /,
+	// e.g. https://oidc.eks.*.amazonaws.com/id/*
+	AllowedPatterns []string `mapstructure:"allowedPatterns"`
+	// AllowedRegexes are regular expressions matched against the whole issuer URL
+	AllowedRegexes []string `mapstructure:"allowedRegexes"`
+	// DeniedPatterns are globs of issuers which are never trusted
+	DeniedPatterns []string `mapstructure:"deniedPatterns"`
+}
+
+type RejectedIssuer struct {
+	Issuer string `json:"issuer"`
+	Reason string `json:"reason"`
+}
+
+// PolicyIssuerProvider filters issuers of another provider by IssuerPolicyConfig
+type PolicyIssuerProvider struct {
+	provider   IssuerProvider
+	normalizer IssuerNormalizer
+	config     IssuerPolicyConfig
+
+	allowed []*regexp.Regexp
+	denied  []*regexp.Regexp
+
+	lock     sync.Mutex
+	rejected map[string]string
+}
+
+func NewPolicyIssuerProvider(provider IssuerProvider, normalizer IssuerNormalizer, config *viper.Viper) (*PolicyIssuerProvider, error) {
+	policyConfig := IssuerPolicyConfig{RequireHTTPS: true}
+	if config != nil {
+		config.SetDefault("requireHTTPS", true)
+		if err := config.Unmarshal(&policyConfig); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal issuer policy")
+		}
+	}
+
+	policy := &PolicyIssuerProvider{
+		provider:   provider,
+		normalizer: normalizer,
+		config:     policyConfig,
+		rejected:   make(map[string]string),
+	}
+
+	if err := policyConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	// patterns are also canonicalized, so that a pattern written in another spelling matches the canonical issuer
+	for _, pattern := range policyConfig.AllowedPatterns {
+		policy.allowed = append(policy.allowed, policy.patternRegexps(pattern)...)
+	}
+	for _, expr := range policyConfig.AllowedRegexes {
+		policy.allowed = append(policy.allowed, regexp.MustCompile(expr))
+	}
+	for _, pattern := range policyConfig.DeniedPatterns {
+		policy.denied = append(policy.denied, policy.patternRegexps(pattern)...)
+	}
+
+	return policy, nil
+}
+
+func (config IssuerPolicyConfig) Validate() error {
+	for _, expr := range config.AllowedRegexes {
+		if _, err := regexp.Compile(expr); err != nil {
+			return errors.Wrapf(err, "invalid allowed regex %s", expr)
+		}
+	}
+
+	for _, pattern := range append(append([]string{}, config.AllowedPatterns...), config.DeniedPatterns...) {
+		if pattern == "" {
+			return errors.New("empty pattern")
+		}
+	}
+
+	return nil
+}
+
+// Issuers returns issuers of the underlying provider which pass the policy, along with its error
+func (policy *PolicyIssuerProvider) Issuers(ctx context.Context) ([]Issuer, error) {
+	provided, err := policy.provider.Issuers(ctx)
+
+	issuers := make([]Issuer, 0, len(provided))
+	rejected := make(map[string]string)
+
+	for _, issuer := range provided {
+		if err := policy.checkSpellings(issuer); err != nil {
+			rejected[issuer.URL] = err.Error()
+			continue
+		}
+
+		issuers = append(issuers, issuer)
+	}
+
+	policy.setRejected(ctx, rejected)
+
+	return issuers, err
+}
+
+// checkSpellings checks the URL and every alias of issuer. the issuer is trusted only if all of its spellings are.
+func (policy *PolicyIssuerProvider) checkSpellings(issuer Issuer) error {
+	if err := policy.Check(issuer.URL); err != nil {
+		return err
+	}
+
+	for _, alias := range issuer.Aliases {
+		if err := policy.Check(alias); err != nil {
+			return errors.Wrapf(err, "alias %s", alias)
+		}
+	}
+
+	return nil
+}
+
+// Check returns an error describing why issuer is not trusted, nil if trusted.
+// issuer is denied if either the issuer or its canonical form matches a denied pattern.
+func (policy *PolicyIssuerProvider) Check(issuer string) error {
+	parsed, err := url.Parse(issuer)
+	if err != nil {
+		return errors.Wrap(err, "invalid url")
+	}
+
+	if parsed.Host == "" {
+		return errors.New("no host")
+	}
+
+	if policy.config.RequireHTTPS && !strings.EqualFold(parsed.Scheme, "https") {
+		return errors.Errorf("scheme %s is not https", parsed.Scheme)
+	}
+
+	spellings := []string{issuer, policy.normalizer.Canonical(issuer)}
+
+	for _, denied := range policy.denied {
+		if matchesAny(denied, spellings) {
+			return errors.Errorf("denied by pattern %s", denied)
+		}
+	}
+
+	if !policy.hasAllowRules() {
+		return nil
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, allowed := range policy.config.AllowedHosts {
+		if host == strings.ToLower(allowed) {
+			return nil
+		}
+	}
+
+	for _, domain := range policy.config.AllowedDomains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return nil
+		}
+	}
+
+	for _, allowed := range policy.allowed {
+		if matchesAny(allowed, spellings) {
+			return nil
+		}
+	}
+
+	return errors.New("not matched by any allow rule")
+}
+
+// Rejected returns issuers rejected by the latest lookup, sorted by issuer
+func (policy *PolicyIssuerProvider) Rejected() []RejectedIssuer {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+
+	rejected := make([]RejectedIssuer, 0, len(policy.rejected))
+	for issuer, reason := range policy.rejected {
+		rejected = append(rejected, RejectedIssuer{Issuer: issuer, Reason: reason})
+	}
+
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].Issuer < rejected[j].Issuer
+	})
+
+	return rejected
+}
+
+// setRejected replaces rejected issuers with the result of the latest lookup
+func (policy *PolicyIssuerProvider) setRejected(ctx context.Context, rejected map[string]string) {
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+
+	// log only newly rejected issuers, since Issuers is called on every request
+	for issuer, reason := range rejected {
+		if policy.rejected[issuer] != reason {
+			logging.FromContext(ctx).Warnf("issuer rejected by policy. issuer: %s, reason: %s", issuer, reason)
+		}
+	}
+
+	policy.rejected = rejected
+}
+
+func (policy *PolicyIssuerProvider) hasAllowRules() bool {
+	return len(policy.config.AllowedHosts) > 0 || len(policy.config.AllowedDomains) > 0 || len(policy.allowed) > 0
+}
+
+// patternRegexps returns regexps of pattern and of its canonical form, if different
+func (policy *PolicyIssuerProvider) patternRegexps(pattern string) []*regexp.Regexp {
+	regexps := []*regexp.Regexp{globToRegexp(pattern)}
+	if canonical := policy.normalizer.Canonical(pattern); canonical != pattern {
+		regexps = append(regexps, globToRegexp(canonical))
+	}
+
+	return regexps
+}
+
+func matchesAny(expr *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if expr.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// globToRegexp converts a glob, where * matches any characters except /, to an anchored regular expression
+func globToRegexp(glob string) *regexp.Regexp {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.MustCompile(fmt.Sprintf("^%s$", strings.Join(parts, "[^/]*")))
+}
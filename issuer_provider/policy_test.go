@@ -0,0 +1,129 @@
+package issuer_provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+type fixedIssuers []Issuer
+
+func (issuers fixedIssuers) Issuers(ctx context.Context) ([]Issuer, error) {
+	return issuers, nil
+}
+
+func newTestPolicy(t *testing.T, provider IssuerProvider, settings map[string]interface{}) *PolicyIssuerProvider {
+	config := viper.New()
+	for key, value := range settings {
+		config.Set(key, value)
+	}
+
+	normalizer, err := NewIssuerNormalizer(nil)
+	if err != nil {
+		t.Fatalf("failed to create normalizer: %v", err)
+	}
+
+	policy, err := NewPolicyIssuerProvider(provider, normalizer, config)
+	if err != nil {
+		t.Fatalf("failed to create policy: %v", err)
+	}
+
+	return policy
+}
+
+func TestPolicyCheckDeniedSpellings(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		issuer  string
+	}{
+		{"exact", "http://127.0.0.1:18081", "http://127.0.0.1:18081"},
+		{"trailing slash", "http://127.0.0.1:18081", "http://127.0.0.1:18081/"},
+		{"pattern with trailing slash", "http://127.0.0.1:18081/", "http://127.0.0.1:18081"},
+		{"uppercase host", "https://issuer.example.com/oidc", "https://ISSUER.example.com/oidc"},
+		{"uppercase scheme", "https://issuer.example.com/oidc", "HTTPS://issuer.example.com/oidc"},
+		{"default port", "https://issuer.example.com/oidc", "https://issuer.example.com:443/oidc"},
+		{"uppercase pattern", "https://ISSUER.example.com/oidc", "https://issuer.example.com/oidc/"},
+		{"glob", "https://*.example.com/oidc", "https://Issuer.Example.com:443/oidc/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := newTestPolicy(t, fixedIssuers{}, map[string]interface{}{
+				"requireHTTPS":   false,
+				"deniedPatterns": []string{test.pattern},
+			})
+
+			if err := policy.Check(test.issuer); err == nil {
+				t.Errorf("%s is not denied by %s", test.issuer, test.pattern)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckAllowedSpellings(t *testing.T) {
+	policy := newTestPolicy(t, fixedIssuers{}, map[string]interface{}{
+		"allowedPatterns": []string{"https://issuer.example.com/oidc"},
+	})
+
+	for _, issuer := range []string{"https://issuer.example.com/oidc", "https://Issuer.example.com:443/oidc/"} {
+		if err := policy.Check(issuer); err != nil {
+			t.Errorf("%s is not allowed: %v", issuer, err)
+		}
+	}
+	if err := policy.Check("https://other.example.com/oidc"); err == nil {
+		t.Errorf("issuer not matched by allowed patterns is allowed")
+	}
+}
+
+func TestPolicyIssuersDeniedAlias(t *testing.T) {
+	normalizer, _ := NewIssuerNormalizer(nil)
+	chain := NewChainIssuerProvider(normalizer,
+		fixedIssuers{{URL: "https://issuer.example.com/oidc"}},
+		fixedIssuers{{URL: "https://issuer.example.com/oidc/"}, {URL: "https://trusted.example.com"}},
+	)
+	// the denied spelling is dropped by deduplication and kept as an alias only
+	policy := newTestPolicy(t, chain, map[string]interface{}{
+		"deniedPatterns": []string{"https://issuer.example.com/oidc/"},
+	})
+
+	issuers, err := policy.Issuers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(issuers) != 1 || issuers[0].URL != "https://trusted.example.com" {
+		t.Errorf("expected only https://trusted.example.com, got %v", issuers)
+	}
+
+	rejected := policy.Rejected()
+	if len(rejected) != 1 || rejected[0].Issuer != "https://issuer.example.com/oidc" {
+		t.Errorf("expected https://issuer.example.com/oidc to be rejected, got %v", rejected)
+	}
+}
+
+func TestPolicyIssuersDeniedAliasOnly(t *testing.T) {
+	normalizer, _ := NewIssuerNormalizer(nil)
+	chain := NewChainIssuerProvider(normalizer,
+		fixedIssuers{{URL: "https://issuer.example.com/oidc"}},
+		fixedIssuers{{URL: "https://issuer.example.com:443/oidc"}},
+	)
+	// a glob over the port can't be canonicalized, so it matches the alias only
+	policy := newTestPolicy(t, chain, map[string]interface{}{
+		"deniedPatterns": []string{"https://issuer.example.com:*/oidc"},
+	})
+
+	issuers, err := policy.Issuers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(issuers) != 0 {
+		t.Errorf("expected the issuer to be rejected by its alias, got %v", issuers)
+	}
+
+	rejected := policy.Rejected()
+	if len(rejected) != 1 || !strings.Contains(rejected[0].Reason, "alias https://issuer.example.com:443/oidc") {
+		t.Errorf("expected https://issuer.example.com/oidc to be rejected by its alias, got %v", rejected)
+	}
+}
@@ -1,5 +1,32 @@
 package issuer_provider
 
+import "context"
+
+// Issuer is a trusted issuer and where it came from
+type Issuer struct {
+	URL string `json:"url"`
+	// Source names the provider or registry the issuer came from, e.g. an endpoint url
+	Source string            `json:"source"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// TTLSeconds caps the key cache TTL of the issuer. 0 means the key provider default.
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	// Aliases are other exact spellings of the same canonical issuer, dropped by deduplication
+	Aliases []string `json:"aliases,omitempty"`
+}
+
 type IssuerProvider interface {
-	Issuers() []string
+	// Issuers returns trusted issuers.
+	// on error, issuers which could still be resolved, e.g. last known issuers of a failing registry, are returned along with it.
+	// so an empty result without error means no issuers are configured.
+	Issuers(ctx context.Context) ([]Issuer, error)
+}
+
+// IssuerURLs returns URLs of issuers
+func IssuerURLs(issuers []Issuer) []string {
+	urls := make([]string, len(issuers))
+	for i, issuer := range issuers {
+		urls[i] = issuer.URL
+	}
+
+	return urls
 }
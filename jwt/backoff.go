@@ -0,0 +1,230 @@
+package jwt
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+func (state CircuitState) MarshalText() ([]byte, error) {
+	return []byte(state.String()), nil
+}
+
+// BackoffPolicy controls how often a failing issuer is retried.
+// failures below FailureThreshold are retried with exponential backoff,
+// after that the circuit opens and only a single probe is allowed every OpenDuration.
+type BackoffPolicy struct {
+	InitialInterval  time.Duration
+	MaxInterval      time.Duration
+	Multiplier       float64
+	Jitter           float64
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+func DefaultBackoffPolicy() BackoffPolicy {
+	return BackoffPolicy{
+		InitialInterval:  1 * time.Second,
+		MaxInterval:      5 * time.Minute,
+		Multiplier:       2,
+		Jitter:           0.2,
+		FailureThreshold: 5,
+		OpenDuration:     1 * time.Minute,
+	}
+}
+
+// interval returns the backoff interval after given number of consecutive failures, without jitter
+func (policy BackoffPolicy) interval(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	interval := float64(policy.InitialInterval) * math.Pow(multiplier, float64(failures-1))
+	if policy.MaxInterval > 0 && interval > float64(policy.MaxInterval) {
+		return policy.MaxInterval
+	}
+
+	return time.Duration(interval)
+}
+
+func (policy BackoffPolicy) withJitter(interval time.Duration) time.Duration {
+	if policy.Jitter <= 0 || interval <= 0 {
+		return interval
+	}
+
+	// spread retries of multiple replicas over [interval * (1 - jitter), interval * (1 + jitter)]
+	delta := policy.Jitter * float64(interval)
+	return time.Duration(float64(interval) - delta + rand.Float64()*2*delta)
+}
+
+// BackoffError is returned from Update when the issuer is still in backoff or the circuit is open.
+type BackoffError struct {
+	Issuer  string
+	State   CircuitState
+	RetryAt time.Time
+	Cause   error
+}
+
+func (err *BackoffError) Error() string {
+	return fmt.Sprintf("skipping update of issuer %s. circuit: %s, retry at: %s, last error: %v", err.Issuer, err.State, err.RetryAt, err.Cause)
+}
+
+func (err *BackoffError) Unwrap() error {
+	return err.Cause
+}
+
+type FailureStatus struct {
+	Circuit             CircuitState `json:"circuit"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	LastError           string       `json:"lastError,omitempty"`
+	LastFailure         *time.Time   `json:"lastFailure,omitempty"`
+	LastSuccess         *time.Time   `json:"lastSuccess,omitempty"`
+	RetryAt             *time.Time   `json:"retryAt,omitempty"`
+}
+
+// FailureTracker tracks consecutive failures of a single issuer.
+// it is safe for concurrent use.
+type FailureTracker struct {
+	lock   sync.Mutex
+	policy BackoffPolicy
+
+	state       CircuitState
+	failures    int
+	lastError   error
+	lastFailure time.Time
+	lastSuccess time.Time
+	retryAt     time.Time
+}
+
+func NewFailureTracker(policy BackoffPolicy) *FailureTracker {
+	return &FailureTracker{
+		policy: policy,
+		state:  CircuitClosed,
+	}
+}
+
+// Allow reports whether an attempt may be made at the given time.
+// an open circuit becomes half-open once its open duration has passed, allowing one probe.
+func (tracker *FailureTracker) Allow(now time.Time) bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	switch tracker.state {
+	case CircuitOpen:
+		if now.Before(tracker.retryAt) {
+			return false
+		}
+
+		tracker.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		// probe already in flight or failed without being recorded. let it through.
+		return true
+	default:
+		return !now.Before(tracker.retryAt)
+	}
+}
+
+func (tracker *FailureTracker) RecordSuccess(now time.Time) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker.state = CircuitClosed
+	tracker.failures = 0
+	tracker.lastError = nil
+	tracker.lastSuccess = now
+	tracker.retryAt = time.Time{}
+}
+
+func (tracker *FailureTracker) RecordFailure(err error, now time.Time) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker.failures++
+	tracker.lastError = err
+	tracker.lastFailure = now
+
+	threshold := tracker.policy.FailureThreshold
+	if tracker.state == CircuitHalfOpen || (threshold > 0 && tracker.failures >= threshold) {
+		tracker.state = CircuitOpen
+		tracker.retryAt = now.Add(tracker.policy.withJitter(tracker.policy.OpenDuration))
+		return
+	}
+
+	tracker.retryAt = now.Add(tracker.policy.withJitter(tracker.policy.interval(tracker.failures)))
+}
+
+// Failing reports whether the last attempt failed
+func (tracker *FailureTracker) Failing() bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	return tracker.failures > 0
+}
+
+// Err returns BackoffError describing why attempts are currently suppressed
+func (tracker *FailureTracker) Err(issuer string) error {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	return &BackoffError{
+		Issuer:  issuer,
+		State:   tracker.state,
+		RetryAt: tracker.retryAt,
+		Cause:   tracker.lastError,
+	}
+}
+
+func (tracker *FailureTracker) Status() FailureStatus {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	status := FailureStatus{
+		Circuit:             tracker.state,
+		ConsecutiveFailures: tracker.failures,
+		LastFailure:         timePtr(tracker.lastFailure),
+		LastSuccess:         timePtr(tracker.lastSuccess),
+		RetryAt:             timePtr(tracker.retryAt),
+	}
+	if tracker.lastError != nil {
+		status.LastError = tracker.lastError.Error()
+	}
+
+	return status
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,121 @@
+package jwt
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pquerna/cachecontrol/cacheobject"
+)
+
+// CachePolicy bounds the TTL derived from upstream cache headers
+type CachePolicy struct {
+	// DefaultTTL is used if upstream does not send any usable cache header
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+	// MinTTL is a floor applied last, so upstreams sending max-age=0 or no-store are not fetched on every request
+	MinTTL time.Duration
+}
+
+func (policy CachePolicy) ttl(headerTTL time.Duration) time.Duration {
+	ttl := headerTTL
+	if ttl < 0 {
+		ttl = policy.DefaultTTL
+	}
+
+	if ttl > policy.MaxTTL {
+		ttl = policy.MaxTTL
+	}
+
+	if ttl < policy.MinTTL {
+		ttl = policy.MinTTL
+	}
+
+	return ttl
+}
+
+// cacheLifetime is the freshness information of an upstream response
+type cacheLifetime struct {
+	// ttl is the remaining freshness lifetime, negative if upstream sent no usable directive
+	ttl                  time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+}
+
+// HeaderTTL returns the freshness lifetime given by cache headers of a response, negative if there is no usable directive
+func HeaderTTL(header http.Header, now time.Time) time.Duration {
+	return parseCacheLifetime(header, now).ttl
+}
+
+// parseCacheLifetime computes freshness of a response as a shared cache (RFC 9111 section 4.2).
+// s-maxage takes precedence over max-age, which takes precedence over Expires.
+// Age is subtracted from the lifetime. no-store and no-cache make the response stale immediately
+// and disable serving it stale.
+func parseCacheLifetime(header http.Header, now time.Time) cacheLifetime {
+	lifetime := cacheLifetime{ttl: -1}
+
+	directives, err := cacheobject.ParseResponseCacheControl(header.Get("Cache-Control"))
+	if err != nil {
+		return lifetime
+	}
+
+	if directives.NoStore || directives.NoCachePresent {
+		lifetime.ttl = 0
+		return lifetime
+	}
+
+	switch {
+	case directives.SMaxAge >= 0:
+		lifetime.ttl = time.Duration(directives.SMaxAge) * time.Second
+	case directives.MaxAge >= 0:
+		lifetime.ttl = time.Duration(directives.MaxAge) * time.Second
+	default:
+		if expires, ok := parseHTTPDate(header.Get("Expires")); ok {
+			date, ok := parseHTTPDate(header.Get("Date"))
+			if !ok {
+				date = now
+			}
+
+			lifetime.ttl = maxDuration(expires.Sub(date), 0)
+		} else if header.Get("Expires") != "" {
+			// invalid Expires, e.g. "0", means already expired
+			lifetime.ttl = 0
+		}
+	}
+
+	if lifetime.ttl > 0 {
+		if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+			lifetime.ttl = maxDuration(lifetime.ttl-time.Duration(age)*time.Second, 0)
+		}
+	}
+
+	if directives.StaleWhileRevalidate > 0 {
+		lifetime.staleWhileRevalidate = time.Duration(directives.StaleWhileRevalidate) * time.Second
+	}
+	if directives.StaleIfError > 0 {
+		lifetime.staleIfError = time.Duration(directives.StaleIfError) * time.Second
+	}
+
+	return lifetime
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	parsed, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return parsed, true
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+
+	return b
+}
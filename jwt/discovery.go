@@ -0,0 +1,116 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
+	"github.com/pkg/errors"
+	"github.com/zitadel/oidc/v2/pkg/oidc"
+)
+
+// discover returns jwks_uri of the issuer, fetching the discovery document only if the cached one expired.
+// caller must hold keySet.lock, which makes it the only writer, so reading the discovery fields without keySet.stateLock is safe.
+func (keySet *CachedJsonWebKeySet) discover(ctx context.Context, httpClient *http.Client, policy CachePolicy) (string, error) {
+	now := time.Now()
+	if keySet.discovery != nil && now.Before(keySet.discoveryNextRefresh) {
+		logging.FromContext(ctx).Debugf("discovery document not expired. issuer: %s, next refresh: %s\n", keySet.issuer, keySet.discoveryNextRefresh)
+		return keySet.discovery.JwksURI, nil
+	}
+
+	documentURL, err := keySet.DiscoveryURL()
+	if err != nil {
+		return "", err
+	}
+
+	conf, ttl, err := fetchDiscovery(ctx, documentURL, httpClient)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to discover OIDC configuration. issuer: %s", keySet.issuer)
+	}
+
+	if !keySet.hasSpelling(conf.Issuer) {
+		if keySet.options.StrictIssuer {
+			return "", errors.Wrapf(oidc.ErrIssuerInvalid, "issuer: %s, discovered: %s", keySet.issuer, conf.Issuer)
+		}
+
+		logging.FromContext(ctx).Warnf("issuer mismatch allowed by configuration. issuer: %s, discovered: %s\n", keySet.issuer, conf.Issuer)
+	}
+
+	if conf.JwksURI == "" {
+		return "", errors.Errorf("discovery document has no jwks_uri. issuer: %s", keySet.issuer)
+	}
+
+	if keySet.discovery != nil && keySet.discovery.JwksURI != conf.JwksURI {
+		logging.FromContext(ctx).Warnf("jwks_uri changed. issuer: %s, old: %s, new: %s\n", keySet.issuer, keySet.discovery.JwksURI, conf.JwksURI)
+	}
+
+	discoveryNextRefresh := now.Add(policy.ttl(ttl))
+	keySet.stateLock.Lock()
+	keySet.discovery = conf
+	keySet.discoveryNextRefresh = discoveryNextRefresh
+	keySet.stateLock.Unlock()
+	logging.FromContext(ctx).Debugf("discovery document updated. issuer: %s, jwks_uri: %s, next refresh: %s\n", keySet.issuer, conf.JwksURI, discoveryNextRefresh)
+
+	return conf.JwksURI, nil
+}
+
+// MetadataURL builds the URL of the metadata document of issuer.
+// with insertion, the well-known path is inserted between the host and the path of issuer as defined in RFC 8414 section 3.1,
+// otherwise it is appended to issuer as defined in OpenID Connect Discovery 1.0 section 4.
+func MetadataURL(issuer, wellKnownPath string, insertion bool) (string, error) {
+	if !insertion {
+		documentURL, err := url.JoinPath(issuer, wellKnownPath)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to join url")
+		}
+
+		return documentURL, nil
+	}
+
+	parsed, err := url.Parse(issuer)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse issuer %s", issuer)
+	}
+
+	issuerPath := strings.TrimSuffix(parsed.Path, "/")
+	parsed.Path = "/" + strings.Trim(wellKnownPath, "/") + issuerPath
+	parsed.RawPath = ""
+
+	return parsed.String(), nil
+}
+
+func fetchDiscovery(ctx context.Context, documentURL string, httpClient *http.Client) (*oidc.DiscoveryConfiguration, time.Duration, error) {
+	logging.FromContext(ctx).Debugf("fetching OIDC document from %s\n", documentURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, documentURL, nil)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to create request to %s", documentURL)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to get OIDC document from %s", documentURL)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, errors.Errorf("unexpected status code %d from %s", res.StatusCode, documentURL)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to read OIDC document")
+	}
+
+	conf := new(oidc.DiscoveryConfiguration)
+	if err := json.Unmarshal(body, conf); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to unmarshal OIDC document")
+	}
+
+	return conf, parseCacheLifetime(res.Header, time.Now()).ttl, nil
+}
@@ -1,8 +1,13 @@
 package jwt
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"time"
 
+	"github.com/zitadel/oidc/v2/pkg/op"
 	"gopkg.in/square/go-jose.v2"
 )
 
@@ -19,16 +24,27 @@ func NewJsonWebKey(jwk jose.JSONWebKey, expires time.Time) JsonWebKey {
 	}
 }
 
+// Algorithm returns alg of the key, or the algorithm inferred from the key type and curve if alg is absent.
+// empty for RSA keys without alg, which can be used with any RSA algorithm.
 func (key *JsonWebKey) Algorithm() jose.SignatureAlgorithm {
-	return jose.SignatureAlgorithm(key.JSONWebKey.Algorithm)
+	if key.JSONWebKey.Algorithm != "" {
+		return jose.SignatureAlgorithm(key.JSONWebKey.Algorithm)
+	}
+
+	return InferAlgorithm(key.JSONWebKey.Key)
 }
 
 func (key *JsonWebKey) Use() string {
 	return key.JSONWebKey.Use
 }
 
+// Key returns the public key. private material published by an upstream by mistake is never served.
 func (key *JsonWebKey) Key() interface{} {
-	return key.JSONWebKey.Key
+	if key.JSONWebKey.IsPublic() {
+		return key.JSONWebKey.Key
+	}
+
+	return key.JSONWebKey.Public().Key
 }
 
 func (key *JsonWebKey) ID() string {
@@ -38,3 +54,65 @@ func (key *JsonWebKey) ID() string {
 func (key *JsonWebKey) Expires(time time.Time) bool {
 	return key.expires.Before(time)
 }
+
+// MarshalJSON serializes the key as served, rather than the upstream key promoted from jose.JSONWebKey
+func (key JsonWebKey) MarshalJSON() ([]byte, error) {
+	jwk := PublicJSONWebKey(&key)
+	return jwk.MarshalJSON()
+}
+
+// PublicJSONWebKey builds the served JWK of key from its accessors as op.Keys does, so only public members are served
+func PublicJSONWebKey(key op.Key) jose.JSONWebKey {
+	return jose.JSONWebKey{
+		KeyID:     key.ID(),
+		Algorithm: string(key.Algorithm()),
+		Use:       key.Use(),
+		Key:       key.Key(),
+	}
+}
+
+// algorithmsByCurve are the algorithms of each EC curve (RFC 7518 section 3.4)
+var algorithmsByCurve = map[string]jose.SignatureAlgorithm{
+	"P-256": jose.ES256,
+	"P-384": jose.ES384,
+	"P-521": jose.ES512,
+}
+
+// InferAlgorithm returns the only signature algorithm of key: ES256, ES384 or ES512 by curve for EC, and EdDSA for Ed25519.
+// empty for RSA keys, which are not bound to a single algorithm, and for unknown keys.
+func InferAlgorithm(key interface{}) jose.SignatureAlgorithm {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return curveAlgorithm(key.Curve)
+	case *ecdsa.PrivateKey:
+		return curveAlgorithm(key.Curve)
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return jose.EdDSA
+	default:
+		return ""
+	}
+}
+
+func curveAlgorithm(curve elliptic.Curve) jose.SignatureAlgorithm {
+	if curve == nil {
+		return ""
+	}
+
+	return algorithmsByCurve[curve.Params().Name]
+}
+
+// AlgorithmMatches returns whether alg can be used with key. RSA keys are used with any RSA algorithm,
+// while EC and Ed25519 keys are bound to a single algorithm. any alg matches unknown keys.
+func AlgorithmMatches(alg jose.SignatureAlgorithm, key interface{}) bool {
+	switch key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		switch alg {
+		case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+			return true
+		}
+		return false
+	}
+
+	inferred := InferAlgorithm(key)
+	return inferred == "" || alg == inferred
+}
@@ -0,0 +1,98 @@
+package jwt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestAlgorithm(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		expected jose.SignatureAlgorithm
+		matches  []jose.SignatureAlgorithm
+		mismatch []jose.SignatureAlgorithm
+	}{
+		{"RSA", rsaKey, "", []jose.SignatureAlgorithm{jose.RS256, jose.RS512, jose.PS256}, []jose.SignatureAlgorithm{jose.ES256, jose.EdDSA}},
+		{"EC", ecKey, jose.ES256, []jose.SignatureAlgorithm{jose.ES256}, []jose.SignatureAlgorithm{jose.ES384, jose.RS256}},
+		{"Ed25519", ed25519Key, jose.EdDSA, []jose.SignatureAlgorithm{jose.EdDSA}, []jose.SignatureAlgorithm{jose.ES256, jose.RS256}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var jwk jose.JSONWebKey
+			if err := jwk.UnmarshalJSON([]byte(test.key)); err != nil {
+				t.Fatalf("invalid key: %v", err)
+			}
+
+			// alg is inferred only if absent
+			jwk.Algorithm = ""
+			key := NewJsonWebKey(jwk, time.Time{})
+			if alg := key.Algorithm(); alg != test.expected {
+				t.Errorf("expected alg %q, got %q", test.expected, alg)
+			}
+
+			for _, alg := range test.matches {
+				if !AlgorithmMatches(alg, jwk.Key) {
+					t.Errorf("%s does not match", alg)
+				}
+			}
+			for _, alg := range test.mismatch {
+				if AlgorithmMatches(alg, jwk.Key) {
+					t.Errorf("%s matches", alg)
+				}
+			}
+		})
+	}
+}
+
+func TestMarshalPrivateKey(t *testing.T) {
+	// keys published with private members by mistake
+	privateKeys := []string{
+		`{"use":"sig","kty":"RSA","kid":"rsa-private","n":"vnz2O_ZyRe2L7X-ID7Hk2gso0eU4mQa84fhtUCDlNqT4cKVLgTPLGaOoj4BQRGUnESvk8eTWeRi656wf00NksjYQpz26nwlq9S7th6qOqAH8DM-4rQ5SsHAcTluPf8MamZF6_sXQ9DpGYvj_PuGhbGafbh1zVoycKMjQ_IdEvjE","e":"AQAB","d":"Ro6c2lsPus0hXmg1qrKW9RjbFtwT2cLAJedR_jMhE9uJVpgzXnkjyqxvHDBICv14ugiLNfowYwyA9p0v8J3u5tvAyZzGM3P7KC01n-oeKiyZKL85SehBbU-D49r7QMhzYdvkOyDwAplIvkBM46bb8NauFv-kOp4US1KCqKxd2m0","p":"7paW5NHlB1r_opUKKPvtGbAw4DmS5Zw1WumLLyObevszFcp9mdAtVJ6cG3PFGdVXAPebfSUvY_uynwz7H778vw","q":"zGO-uiopO6WM0wPut-TWO_E8NFF1XTo-tWMnPocKB0OZ0_JSJXErMgOdY2Fl-mEZbfoazf96dPrC4thbZETRDw","dp":"Vnkb_ZkZgl338yxDf3E3kSzrugkD9dZ0_BtTR-WG777l2AsJFNt_4oCOIeEG2gMZwo77uxLkJz_NkJn61pm5Lw","dq":"Xkv2hrVE6LvsSL6buEPfgzA88nZSi3x_yffA91weCFPr-JjnxVZ880ovptYc0nNR5Cdrjna77JR0rWvcXlpRiQ","qi":"mC3Yi8IJdkRFOJ_bCStvqhp3z5igj9lpp4TdrfrW02_UxeZRjDCMhSW3TJ7jYLrSMByw9ldEdNcK79uRoSi-ZQ"}`,
+		`{"use":"sig","kty":"EC","kid":"ec-private","crv":"P-256","alg":"ES256","x":"tU19OzdWj5PbVNEDR42AnaFBnEgwckvNcAsd24gLpOQ","y":"oUaGzY9oDRZcEnP4acJ7imgi5zE0bBZJKf6-kh0SGes","d":"D4Kvjxe5zwuS8HFcye2-ILDSOF7SvG-CkLHC7Jhn9Fc"}`,
+	}
+	publicMembers := map[string]struct{}{"kty": {}, "kid": {}, "use": {}, "alg": {}, "n": {}, "e": {}, "crv": {}, "x": {}, "y": {}}
+
+	for _, privateKey := range privateKeys {
+		jwks, err := ParseJWKSWithOptions(jwksOf(privateKey), true)
+		if err != nil || len(jwks.Keys) != 1 {
+			t.Fatalf("invalid key: %v", err)
+		}
+		key := NewJsonWebKey(jwks.Keys[0], time.Time{})
+
+		served := PublicJSONWebKey(&key)
+		fromAccessors, err := served.MarshalJSON()
+		if err != nil {
+			t.Fatalf("failed to marshal %s: %v", key.ID(), err)
+		}
+		// also marshaled directly, both as a value and as a pointer
+		fromValue, err := json.Marshal(key)
+		if err != nil {
+			t.Fatalf("failed to marshal %s: %v", key.ID(), err)
+		}
+		fromPointer, err := json.Marshal(&key)
+		if err != nil {
+			t.Fatalf("failed to marshal %s: %v", key.ID(), err)
+		}
+
+		for _, body := range [][]byte{fromAccessors, fromValue, fromPointer} {
+			var members map[string]json.RawMessage
+			if err := json.Unmarshal(body, &members); err != nil {
+				t.Fatalf("invalid JSON %s: %v", body, err)
+			}
+
+			for member := range members {
+				if _, ok := publicMembers[member]; !ok {
+					t.Errorf("%s: %s is served: %s", key.ID(), member, body)
+				}
+			}
+			if _, ok := members["kty"]; !ok {
+				t.Errorf("%s: kty is not served: %s", key.ID(), body)
+			}
+		}
+	}
+}
@@ -2,36 +2,80 @@
 
 import (
 	"context"
-	"encoding/json"
-	"go.uber.org/zap"
 	"io"
 	"net/http"
-	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
 	"github.com/krafton-hq/oidc-discovery-server/util/perf"
 	"github.com/pkg/errors"
-	"github.com/pquerna/cachecontrol/cacheobject"
-	"github.com/zitadel/oidc/v2/pkg/client"
+	"github.com/zitadel/oidc/v2/pkg/oidc"
 	"github.com/zitadel/oidc/v2/pkg/op"
-	"gopkg.in/square/go-jose.v2"
 )
 
+type KeySetOptions struct {
+	Backoff BackoffPolicy
+	// StrictIssuer rejects a discovery document whose issuer is not exactly one of the spellings the key set was looked up with.
+	StrictIssuer bool
+	// MetadataPath is the well-known path of the metadata document. defaults to OIDCDocumentPath
+	MetadataPath string
+	// MetadataPathInsertion inserts MetadataPath between host and path of the issuer (RFC 8414) instead of appending it
+	MetadataPathInsertion bool
+	// StrictKeys rejects the whole key set if any key is invalid, instead of skipping invalid keys
+	StrictKeys bool
+}
+
 type CachedJsonWebKeySet struct {
+	// lock serializes updates. it is held while fetching, so readers must not take it
 	lock sync.Mutex
+	// stateLock guards the fields below read by Keys and Status while an update, possibly in background, replaces them.
+	// updates build the new state while holding lock only, and replace it while holding stateLock.
+	stateLock sync.RWMutex
 
+	// issuer is the spelling used for fetching. spellings are every exact issuer string the key set is looked up with,
+	// which differ only in parts removed by normalization.
 	issuer      string
+	spellings   map[string]struct{}
 	nextRefresh time.Time
 	keys        map[string]JsonWebKey
+	failures    *FailureTracker
+	options     KeySetOptions
+
+	discovery            *oidc.DiscoveryConfiguration
+	discoveryNextRefresh time.Time
+
+	// stale-while-revalidate and stale-if-error of the last fetched key set
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	revalidating         atomic.Bool
+
+	// keyErrors are why keys of the last fetched key set are skipped
+	keyErrors KeyErrors
+}
+
+type KeySetStatus struct {
+	FailureStatus
+
+	Issuer               string    `json:"issuer"`
+	DiscoveryURL         string    `json:"discoveryUrl,omitempty"`
+	JwksURI              string    `json:"jwksUri,omitempty"`
+	NextRefresh          time.Time `json:"nextRefresh"`
+	DiscoveryNextRefresh time.Time `json:"discoveryNextRefresh"`
+	KeyCount             int       `json:"keyCount"`
+	KeyErrors            []string  `json:"keyErrors,omitempty"`
 }
 
-func NewCachedJsonWebKeySet(issuer string) *CachedJsonWebKeySet {
+func NewCachedJsonWebKeySet(issuer string, options KeySetOptions) *CachedJsonWebKeySet {
 	return &CachedJsonWebKeySet{
 		lock:        sync.Mutex{},
 		keys:        make(map[string]JsonWebKey),
 		issuer:      issuer,
+		spellings:   map[string]struct{}{issuer: {}},
 		nextRefresh: time.UnixMilli(0),
+		failures:    NewFailureTracker(options.Backoff),
+		options:     options,
 	}
 }
 
@@ -39,30 +83,140 @@ func (keySet *CachedJsonWebKeySet) Issuer() string {
 	return keySet.issuer
 }
 
+// AddSpelling records issuer as a spelling of the issuer of the key set, accepted as the issuer of the discovery document
+func (keySet *CachedJsonWebKeySet) AddSpelling(issuer string) {
+	if keySet.hasSpelling(issuer) {
+		return
+	}
+
+	keySet.stateLock.Lock()
+	defer keySet.stateLock.Unlock()
+
+	keySet.spellings[issuer] = struct{}{}
+}
+
+func (keySet *CachedJsonWebKeySet) hasSpelling(issuer string) bool {
+	keySet.stateLock.RLock()
+	defer keySet.stateLock.RUnlock()
+
+	_, ok := keySet.spellings[issuer]
+	return ok
+}
+
+func (keySet *CachedJsonWebKeySet) Options() KeySetOptions {
+	return keySet.options
+}
+
+func (keySet *CachedJsonWebKeySet) DiscoveryURL() (string, error) {
+	metadataPath := keySet.options.MetadataPath
+	if metadataPath == "" {
+		metadataPath = OIDCDocumentPath
+	}
+
+	return MetadataURL(keySet.issuer, metadataPath, keySet.options.MetadataPathInsertion)
+}
+
 func (keySet *CachedJsonWebKeySet) Keys() []op.Key {
-	if keySet.ShouldRefresh(time.Now()) {
+	keySet.stateLock.RLock()
+	defer keySet.stateLock.RUnlock()
+
+	return keySet.servableKeys(time.Now())
+}
+
+// servableKeys returns copies of the keys unless they expired and can't be served stale. caller must hold keySet.stateLock
+func (keySet *CachedJsonWebKeySet) servableKeys(now time.Time) []op.Key {
+	if keySet.shouldRefresh(now) && !keySet.canServeStale(now) && !keySet.canServeStaleOnError(now) {
 		return nil
-	} else {
-		keys := make([]op.Key, 0)
-		for _, key := range keySet.keys {
-			copied := key
-			keys = append(keys, &copied)
-		}
+	}
 
-		return keys
+	keys := make([]op.Key, 0, len(keySet.keys))
+	for _, key := range keySet.keys {
+		copied := key
+		keys = append(keys, &copied)
 	}
+
+	return keys
+}
+
+func (keySet *CachedJsonWebKeySet) Status() KeySetStatus {
+	keySet.stateLock.RLock()
+	defer keySet.stateLock.RUnlock()
+
+	status := KeySetStatus{
+		FailureStatus:        keySet.failures.Status(),
+		Issuer:               keySet.issuer,
+		NextRefresh:          keySet.nextRefresh,
+		DiscoveryNextRefresh: keySet.discoveryNextRefresh,
+		KeyCount:             len(keySet.servableKeys(time.Now())),
+	}
+	for _, keyError := range keySet.keyErrors {
+		status.KeyErrors = append(status.KeyErrors, keyError.Error())
+	}
+	if discovery := keySet.discovery; discovery != nil {
+		status.JwksURI = discovery.JwksURI
+	}
+	if discoveryURL, err := keySet.DiscoveryURL(); err == nil {
+		status.DiscoveryURL = discoveryURL
+	}
+
+	return status
+}
+
+// CanServeStale reports whether expired keys may still be served while revalidating in background,
+// as allowed by stale-while-revalidate of the last response
+func (keySet *CachedJsonWebKeySet) CanServeStale(now time.Time) bool {
+	keySet.stateLock.RLock()
+	defer keySet.stateLock.RUnlock()
+
+	return keySet.canServeStale(now)
+}
+
+func (keySet *CachedJsonWebKeySet) canServeStale(now time.Time) bool {
+	return now.Before(keySet.nextRefresh.Add(keySet.staleWhileRevalidate))
+}
+
+// CanServeStaleOnError reports whether expired keys may still be served because the update failed,
+// as allowed by stale-if-error of the last response
+func (keySet *CachedJsonWebKeySet) CanServeStaleOnError(now time.Time) bool {
+	keySet.stateLock.RLock()
+	defer keySet.stateLock.RUnlock()
+
+	return keySet.canServeStaleOnError(now)
+}
+
+func (keySet *CachedJsonWebKeySet) canServeStaleOnError(now time.Time) bool {
+	return keySet.failures.Failing() && now.Before(keySet.nextRefresh.Add(keySet.staleIfError))
+}
+
+// UpdateInBackground starts Update in a new goroutine unless one is already running
+// ctx is only used for the request ID in logs, since the update outlives the request
+func (keySet *CachedJsonWebKeySet) UpdateInBackground(ctx context.Context, httpClient *http.Client, keyPolicy, discoveryPolicy CachePolicy) {
+	if !keySet.revalidating.CompareAndSwap(false, true) {
+		return
+	}
+
+	ctx = logging.Detach(ctx)
+	go func() {
+		defer keySet.revalidating.Store(false)
+
+		if err := keySet.Update(ctx, httpClient, keyPolicy, discoveryPolicy, false); err != nil {
+			logging.FromContext(ctx).Warnf("background update of key set failed. issuer: %s. %v\n", keySet.issuer, err)
+		}
+	}()
 }
 
 // Update updates keySet in place
 // ctx: context
 // httpClient: http client to use
-// defaultKeyTTL: default key TTL if no cache-control header defined in response
-// maxKeyTTL: max key TTL
-// force: force update even if keySet is not expired
+// keyPolicy: TTL bounds of the key set
+// discoveryPolicy: TTL bounds of the discovery document, which is cached independently of the key set
+// force: force update even if keySet is not expired or issuer is in backoff
+//
+// returns *BackoffError without any request if the issuer failed recently and should not be retried yet.
 func (keySet *CachedJsonWebKeySet) Update(
 	ctx context.Context,
 	httpClient *http.Client,
-	defaultKeyTTL, maxKeyTTL time.Duration,
+	keyPolicy, discoveryPolicy CachePolicy,
 	force bool,
 ) error {
 	defer perf.Perf("Update")()
@@ -73,139 +227,139 @@ func (keySet *CachedJsonWebKeySet) Update(
 	if !force {
 		if !keySet.ShouldRefresh(time.Now()) {
 			// somehow it's already updated. probably another goroutine.
-			zap.S().Debugf("key set is not expired. skipping Update.\n")
+			logging.FromContext(ctx).Debugf("key set is not expired. skipping Update.\n")
 			return nil
 		}
+		if !keySet.failures.Allow(time.Now()) {
+			return keySet.failures.Err(keySet.issuer)
+		}
 	} else {
-		zap.S().Debugf("force updating KeySet. issuer: %s.\n", keySet.issuer)
+		logging.FromContext(ctx).Debugf("force updating KeySet. issuer: %s.\n", keySet.issuer)
 	}
 
-	oidcDocumentURL, err := url.JoinPath(keySet.issuer, OIDCDocumentPath)
-	if err != nil {
-		return errors.Wrap(err, "failed to join url")
+	if err := keySet.update(ctx, httpClient, keyPolicy, discoveryPolicy); err != nil {
+		keySet.failures.RecordFailure(err, time.Now())
+		logging.FromContext(ctx).Debugf("key set update failed. issuer: %s, status: %+v\n", keySet.issuer, keySet.failures.Status())
+		return err
 	}
-	zap.S().Debugf("fetching OIDC document from %s\n", oidcDocumentURL)
 
-	conf, err := client.Discover(keySet.issuer, httpClient)
+	keySet.failures.RecordSuccess(time.Now())
+	return nil
+}
+
+// update fetches the key set. caller must hold keySet.lock
+func (keySet *CachedJsonWebKeySet) update(ctx context.Context, httpClient *http.Client, keyPolicy, discoveryPolicy CachePolicy) error {
+	jwksURI, err := keySet.discover(ctx, httpClient, discoveryPolicy)
 	if err != nil {
-		return errors.Wrapf(err, "failed to discover OIDC configuration. issuer: %s", keySet.issuer)
+		if keySet.discovery == nil {
+			return err
+		}
+
+		jwksURI = keySet.discovery.JwksURI
+		logging.FromContext(ctx).Warnf("discovery failed. falling back to last known jwks_uri %s. %v\n", jwksURI, err)
 	}
 
-	fetchedKeySet, keyTTL, err := fetchKeySet(conf.JwksURI, httpClient, defaultKeyTTL)
+	fetchedKeySet, keyErrors, lifetime, err := fetchKeySet(ctx, jwksURI, httpClient, keyPolicy, keySet.options.StrictKeys)
 	if err != nil {
+		// jwks_uri may have moved. rediscover on next attempt.
+		keySet.stateLock.Lock()
+		keySet.discoveryNextRefresh = time.Time{}
+		keySet.stateLock.Unlock()
 		return errors.Wrapf(err, "failed to get key set. issuer: %s", keySet.issuer)
 	}
 
-	if keyTTL > maxKeyTTL {
-		keyTTL = maxKeyTTL
+	for _, keyError := range keyErrors {
+		logging.FromContext(ctx).Warnf("skipping invalid key. issuer: %s, %v\n", keySet.issuer, keyError)
 	}
 
-	keySet.updateInternalKeySet(fetchedKeySet, time.Now())
-	keySet.nextRefresh = time.Now().Add(keyTTL)
-	zap.S().Debugf("jwks updated. issuer: %s. next refresh: %s, keys: %s\n", keySet.Issuer(), keySet.nextRefresh, keySet.Keys())
+	now := time.Now()
+	keys := keySet.mergeKeys(ctx, fetchedKeySet, now)
+
+	keySet.stateLock.Lock()
+	keySet.keys = keys
+	keySet.keyErrors = keyErrors
+	keySet.nextRefresh = now.Add(lifetime.ttl)
+	keySet.staleWhileRevalidate = lifetime.staleWhileRevalidate
+	keySet.staleIfError = lifetime.staleIfError
+	keySet.stateLock.Unlock()
+
+	logging.FromContext(ctx).Debugf("jwks updated. issuer: %s. next refresh: %s, keys: %s\n", keySet.Issuer(), now.Add(lifetime.ttl), keySet.Keys())
 
 	return nil
 }
 
-func (keySet *CachedJsonWebKeySet) updateInternalKeySet(keys []JsonWebKey, now time.Time) {
-	oldKeys := make(map[string]JsonWebKey, len(keySet.keys))
+// mergeKeys returns a new key map of the current keys not expired yet and keys. the current map is never modified,
+// since readers may be iterating it. caller must hold keySet.lock, which is the only writer of keySet.keys.
+func (keySet *CachedJsonWebKeySet) mergeKeys(ctx context.Context, keys []JsonWebKey, now time.Time) map[string]JsonWebKey {
+	merged := make(map[string]JsonWebKey, len(keySet.keys)+len(keys))
 	for _, key := range keySet.keys {
-		oldKeys[key.KeyID] = key
-	}
-
-	for _, key := range oldKeys {
 		if key.Expires(now) {
-			zap.S().Infof("removing expired key. key id: %s, expires: %s\n", key.KeyID, key.expires)
-
-			delete(keySet.keys, key.KeyID)
+			logging.FromContext(ctx).Infof("removing expired key. key id: %s, expires: %s\n", key.KeyID, key.expires)
+			continue
 		}
+
+		merged[key.KeyID] = key
 	}
 
 	for _, key := range keys {
-		if _, ok := keySet.keys[key.KeyID]; ok {
-			zap.S().Infof("updating existing key. key id: %s, expires: %s\n", key.KeyID, key.expires)
+		if _, ok := merged[key.KeyID]; ok {
+			logging.FromContext(ctx).Infof("updating existing key. key id: %s, expires: %s\n", key.KeyID, key.expires)
 		} else {
-			zap.S().Infof("adding new key. key id: %s, expires: %s\n", key.KeyID, key.expires)
+			logging.FromContext(ctx).Infof("adding new key. key id: %s, expires: %s\n", key.KeyID, key.expires)
 		}
 
-		keySet.keys[key.KeyID] = key
+		merged[key.KeyID] = key
 	}
+
+	return merged
 }
 
 // keySet expires function
-func (keySet *CachedJsonWebKeySet) ShouldRefresh(time time.Time) bool {
-	return time.After(keySet.nextRefresh)
-}
+func (keySet *CachedJsonWebKeySet) ShouldRefresh(now time.Time) bool {
+	keySet.stateLock.RLock()
+	defer keySet.stateLock.RUnlock()
 
-func fetchKeySet(jwksUri string, httpClient *http.Client, defaultKeyTTL time.Duration) ([]JsonWebKey, time.Duration, error) {
-	zap.S().Infof("fetching JWKS from %s\n", jwksUri)
+	return keySet.shouldRefresh(now)
+}
 
-	res, err := httpClient.Get(jwksUri)
-	if err != nil {
-		return nil, 0, errors.Wrapf(err, "failed to get JWKS from %s", jwksUri)
-	}
+func (keySet *CachedJsonWebKeySet) shouldRefresh(now time.Time) bool {
+	return now.After(keySet.nextRefresh)
+}
 
-	cache := res.Header.Get("Cache-Control")
-	keyTTL := getKeyTTL(cache)
-	if keyTTL < 0 {
-		keyTTL = defaultKeyTTL
-	}
+// fetchKeySet fetches keys from jwksUri. ttl of the returned lifetime is already bounded by policy.
+// invalid keys are skipped and returned as KeyErrors, or fail the whole key set if strict.
+func fetchKeySet(ctx context.Context, jwksUri string, httpClient *http.Client, policy CachePolicy, strict bool) ([]JsonWebKey, KeyErrors, cacheLifetime, error) {
+	logging.FromContext(ctx).Infof("fetching JWKS from %s\n", jwksUri)
 
-	body, err := io.ReadAll(res.Body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksUri, nil)
 	if err != nil {
-		return nil, 0, errors.Wrapf(err, "failed to read JWKS response body")
-	}
-
-	var data = new(map[string]interface{})
-
-	if err = json.Unmarshal(body, data); err != nil {
-		return nil, 0, errors.Wrapf(err, "failed to unmarshal JWKS response body")
+		return nil, nil, cacheLifetime{}, errors.Wrapf(err, "failed to create request to %s", jwksUri)
 	}
 
-	parsedKeys, err := ParseJWKS(body)
+	res, err := httpClient.Do(req)
 	if err != nil {
-		return nil, 0, err
-	}
-
-	keys := make([]JsonWebKey, 0)
-	for _, key := range parsedKeys {
-		keys = append(keys, NewJsonWebKey(key, time.Now().Add(keyTTL)))
+		return nil, nil, cacheLifetime{}, errors.Wrapf(err, "failed to get JWKS from %s", jwksUri)
 	}
+	defer res.Body.Close()
 
-	return keys, keyTTL, nil
-}
+	lifetime := parseCacheLifetime(res.Header, time.Now())
+	lifetime.ttl = policy.ttl(lifetime.ttl)
+	keyTTL := lifetime.ttl
 
-func ParseJWKS(body []byte) ([]jose.JSONWebKey, error) {
-	var data = new(map[string]interface{})
-
-	if err := json.Unmarshal(body, data); err != nil {
-		return nil, errors.Wrapf(err, "failed to unmarshal JWKS response body")
-	}
-
-	keys := make([]jose.JSONWebKey, 0)
-
-	for _, key := range (*data)["keys"].([]interface{}) {
-		keyBytes, _ := json.Marshal(key)
-		webKey := jose.JSONWebKey{}
-		if err := webKey.UnmarshalJSON(keyBytes); err == nil {
-			keys = append(keys, webKey)
-		} else {
-			zap.S().Warnf("failed to unmarshal key: %v\n", err)
-		}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, cacheLifetime{}, errors.Wrapf(err, "failed to read JWKS response body")
 	}
 
-	return keys, nil
-}
-
-func getKeyTTL(cacheControlHeader string) time.Duration {
-	parsed, err := cacheobject.ParseResponseCacheControl(cacheControlHeader)
+	jwks, err := ParseJWKSWithOptions(body, strict)
 	if err != nil {
-		return -1
+		return nil, nil, cacheLifetime{}, errors.Wrapf(err, "failed to parse JWKS from %s", jwksUri)
 	}
 
-	if parsed.NoCachePresent {
-		return -1
+	keys := make([]JsonWebKey, 0, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		keys = append(keys, NewJsonWebKey(key, time.Now().Add(keyTTL)))
 	}
 
-	return time.Duration(parsed.MaxAge) * time.Second
+	return keys, jwks.Errors, lifetime, nil
 }
@@ -0,0 +1,248 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/square/go-jose.v2"
+)
+
+var (
+	// ErrMalformedJWKS is returned if the JWKS is not a JSON object
+	ErrMalformedJWKS = errors.New("JWKS is not a JSON object")
+	// ErrMissingKeys is returned if the JWKS has no keys member
+	ErrMissingKeys = errors.New("JWKS has no keys")
+	// ErrKeysNotArray is returned if keys of the JWKS is not an array
+	ErrKeysNotArray = errors.New("keys of JWKS is not an array")
+	// ErrInvalidKeys is returned in strict mode if any key is invalid. the error is a KeyErrors matching it by errors.Is.
+	ErrInvalidKeys = errors.New("JWKS has invalid keys")
+)
+
+type KeyErrorReason string
+
+const (
+	KeyErrorMalformed      KeyErrorReason = "malformed"
+	KeyErrorMissingKty     KeyErrorReason = "missing kty"
+	KeyErrorUnsupportedKty KeyErrorReason = "unsupported kty"
+	KeyErrorMissingKid     KeyErrorReason = "missing kid"
+	KeyErrorDuplicateKid   KeyErrorReason = "duplicate kid"
+	KeyErrorBadEncoding    KeyErrorReason = "bad base64"
+	KeyErrorInvalidKey     KeyErrorReason = "invalid key"
+	KeyErrorAlgMismatch    KeyErrorReason = "alg mismatch"
+)
+
+// requiredMembers are base64url encoded members required for each supported kty (RFC 7518 section 6, RFC 8037 section 2)
+var requiredMembers = map[string][]string{
+	"RSA": {"n", "e"},
+	"EC":  {"x", "y"},
+	"OKP": {"x"},
+}
+
+// optionalMembers are base64url encoded members which are checked if present
+var optionalMembers = []string{"d", "p", "q", "dp", "dq", "qi", "x5t", "x5t#S256"}
+
+// KeyError is why a single key of a JWKS is skipped
+type KeyError struct {
+	// Index of the key in keys of the JWKS
+	Index  int
+	KeyID  string
+	Reason KeyErrorReason
+	Err    error
+}
+
+func (err *KeyError) Error() string {
+	key := fmt.Sprintf("key %d", err.Index)
+	if err.KeyID != "" {
+		key = fmt.Sprintf("key %d (kid %s)", err.Index, err.KeyID)
+	}
+
+	if err.Err == nil {
+		return fmt.Sprintf("%s: %s", key, err.Reason)
+	}
+	return fmt.Sprintf("%s: %s: %v", key, err.Reason, err.Err)
+}
+
+func (err *KeyError) Unwrap() error {
+	return err.Err
+}
+
+// KeyErrors are errors of every invalid key of a JWKS
+type KeyErrors []*KeyError
+
+func (errs KeyErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Sprintf("%v: %s", ErrInvalidKeys, strings.Join(messages, "; "))
+}
+
+func (errs KeyErrors) Is(target error) bool {
+	return target == ErrInvalidKeys
+}
+
+// JWKS is a parsed JSON Web Key Set. Errors are reasons of keys skipped by the lenient mode.
+type JWKS struct {
+	Keys   []jose.JSONWebKey
+	Errors KeyErrors
+}
+
+// ParseJWKSWithOptions parses a JSON Web Key Set. an error is returned if the document itself is malformed.
+// invalid keys are skipped and reported in Errors, or rejects the whole set with KeyErrors if strict.
+func ParseJWKSWithOptions(body []byte, strict bool) (JWKS, error) {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(body, &document); err != nil || document == nil {
+		if err == nil {
+			err = errors.New("null")
+		}
+		return JWKS{}, fmt.Errorf("%w: %v", ErrMalformedJWKS, err)
+	}
+
+	rawKeys, ok := document["keys"]
+	if !ok {
+		return JWKS{}, ErrMissingKeys
+	}
+
+	var keyMessages []json.RawMessage
+	if err := json.Unmarshal(rawKeys, &keyMessages); err != nil || keyMessages == nil {
+		return JWKS{}, ErrKeysNotArray
+	}
+
+	jwks := JWKS{
+		Keys: make([]jose.JSONWebKey, 0, len(keyMessages)),
+	}
+	kids := make(map[string]struct{}, len(keyMessages))
+	for i, message := range keyMessages {
+		key, err := parseKey(i, message)
+		if err == nil {
+			if _, ok := kids[key.KeyID]; ok {
+				err = &KeyError{Index: i, KeyID: key.KeyID, Reason: KeyErrorDuplicateKid}
+			}
+		}
+		if err != nil {
+			jwks.Errors = append(jwks.Errors, err)
+			continue
+		}
+
+		kids[key.KeyID] = struct{}{}
+		jwks.Keys = append(jwks.Keys, key)
+	}
+
+	if strict && len(jwks.Errors) > 0 {
+		return JWKS{}, jwks.Errors
+	}
+
+	return jwks, nil
+}
+
+// ParseJWKS parses a JSON Web Key Set, skipping invalid keys
+func ParseJWKS(body []byte) ([]jose.JSONWebKey, error) {
+	jwks, err := ParseJWKSWithOptions(body, false)
+	return jwks.Keys, err
+}
+
+func parseKey(index int, message json.RawMessage) (jose.JSONWebKey, *KeyError) {
+	keyError := func(kid string, reason KeyErrorReason, err error) *KeyError {
+		return &KeyError{Index: index, KeyID: kid, Reason: reason, Err: err}
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(message, &members); err != nil || members == nil {
+		return jose.JSONWebKey{}, keyError("", KeyErrorMalformed, errors.New("not a JSON object"))
+	}
+
+	kid, err := stringMember(members, "kid")
+	if err != nil {
+		return jose.JSONWebKey{}, keyError("", KeyErrorMalformed, err)
+	}
+
+	kty, err := stringMember(members, "kty")
+	if err != nil {
+		return jose.JSONWebKey{}, keyError(kid, KeyErrorMalformed, err)
+	}
+	if kty == "" {
+		return jose.JSONWebKey{}, keyError(kid, KeyErrorMissingKty, nil)
+	}
+	required, ok := requiredMembers[kty]
+	if !ok {
+		return jose.JSONWebKey{}, keyError(kid, KeyErrorUnsupportedKty, errors.Errorf("%q", kty))
+	}
+
+	if kid == "" {
+		return jose.JSONWebKey{}, keyError(kid, KeyErrorMissingKid, nil)
+	}
+
+	for _, name := range required {
+		if _, ok := members[name]; !ok {
+			return jose.JSONWebKey{}, keyError(kid, KeyErrorInvalidKey, errors.Errorf("%s is missing", name))
+		}
+	}
+	for _, name := range append(required, optionalMembers...) {
+		if err := checkBase64Member(members, name); err != nil {
+			return jose.JSONWebKey{}, keyError(kid, KeyErrorBadEncoding, err)
+		}
+	}
+
+	var key jose.JSONWebKey
+	if err := key.UnmarshalJSON(message); err != nil {
+		return jose.JSONWebKey{}, keyError(kid, KeyErrorInvalidKey, err)
+	}
+
+	// alg of encryption keys is a key management algorithm
+	alg := jose.SignatureAlgorithm(key.Algorithm)
+	if key.Use != "enc" && alg != "" && !AlgorithmMatches(alg, key.Key) {
+		return jose.JSONWebKey{}, keyError(kid, KeyErrorAlgMismatch, errors.Errorf("%s is not for %s key", alg, describeKey(key)))
+	}
+
+	return key, nil
+}
+
+// stringMember returns member name of a key, empty if absent
+func stringMember(members map[string]json.RawMessage, name string) (string, error) {
+	raw, ok := members[name]
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		return "", nil
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", errors.Errorf("%s is not a string", name)
+	}
+
+	return value, nil
+}
+
+// checkBase64Member checks member name of a key, if present, is base64url encoded
+func checkBase64Member(members map[string]json.RawMessage, name string) error {
+	value, err := stringMember(members, name)
+	if err != nil {
+		return err
+	}
+
+	// go-jose accepts padded values too
+	if _, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "=")); err != nil {
+		return errors.Errorf("%s is not base64url encoded: %v", name, err)
+	}
+
+	return nil
+}
+
+func describeKey(key jose.JSONWebKey) string {
+	switch key := key.Key.(type) {
+	case *ecdsa.PublicKey:
+		return "EC " + key.Curve.Params().Name
+	case *ecdsa.PrivateKey:
+		return "EC " + key.Curve.Params().Name
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return "Ed25519"
+	default:
+		return "RSA"
+	}
+}
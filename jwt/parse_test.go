@@ -0,0 +1,195 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	rsaKey     = `{"use":"sig","kty":"RSA","kid":"rsa","alg":"RS256","n":"0Bi97fKgu3fSSBb3diWrmF6LLflb3RHzDJdo3Ph026n8y2_zBVDzg2ilX11WNakTXtAb4F7Mg-i8mjF4_nJB63l0ach9ieFKst1-9070cCts1efIn0NhaUdOc0G7zrGFs0Ax8Prya6ImbqrmNM9pmuugtlaTLEDjV5npUYVU-_k","e":"AQAB"}`
+	ecKey      = `{"use":"sig","kty":"EC","kid":"ec","crv":"P-256","alg":"ES256","x":"kujIieCGn3HNDEQJPlS42WRjHZjNe39EY4n372LTBto","y":"lsgbFAqbl3rnnW-ALMM8_e-KyC5t09b_dFd12pyWv1M"}`
+	ed25519Key = `{"use":"sig","kty":"OKP","kid":"ed","crv":"Ed25519","alg":"EdDSA","x":"LC9k4ripLaOvGdPqNcmrNtH0xepYJ8VDUAJZw_I70YI"}`
+)
+
+// invalidKeys are keys rejected by reason, each valid except for one member
+var invalidKeys = []struct {
+	name   string
+	key    string
+	reason KeyErrorReason
+}{
+	{"not an object", `"key"`, KeyErrorMalformed},
+	{"kid not a string", `{"kty":"OKP","kid":1,"crv":"Ed25519","x":"LC9k4ripLaOvGdPqNcmrNtH0xepYJ8VDUAJZw_I70YI"}`, KeyErrorMalformed},
+	{"missing kty", `{"kid":"ed2","crv":"Ed25519","x":"LC9k4ripLaOvGdPqNcmrNtH0xepYJ8VDUAJZw_I70YI"}`, KeyErrorMissingKty},
+	{"unsupported kty", `{"kty":"oct","kid":"hmac","k":"c2VjcmV0"}`, KeyErrorUnsupportedKty},
+	{"missing kid", `{"kty":"OKP","crv":"Ed25519","x":"LC9k4ripLaOvGdPqNcmrNtH0xepYJ8VDUAJZw_I70YI"}`, KeyErrorMissingKid},
+	{"duplicate kid", strings.Replace(ed25519Key, `"kid":"ed"`, `"kid":"ec"`, 1), KeyErrorDuplicateKid},
+	{"bad base64", `{"kty":"OKP","kid":"ed2","crv":"Ed25519","x":"not base64!"}`, KeyErrorBadEncoding},
+	{"missing y", `{"kty":"EC","kid":"ec2","crv":"P-256","x":"kujIieCGn3HNDEQJPlS42WRjHZjNe39EY4n372LTBto"}`, KeyErrorInvalidKey},
+	{"unknown crv", strings.Replace(strings.Replace(ecKey, `"P-256"`, `"P-000"`, 1), `"kid":"ec"`, `"kid":"ec2"`, 1), KeyErrorInvalidKey},
+	{"alg mismatch", strings.Replace(strings.Replace(ecKey, `"ES256"`, `"ES384"`, 1), `"kid":"ec"`, `"kid":"ec2"`, 1), KeyErrorAlgMismatch},
+	{"RSA alg for EC key", strings.Replace(strings.Replace(ecKey, `"ES256"`, `"RS256"`, 1), `"kid":"ec"`, `"kid":"ec2"`, 1), KeyErrorAlgMismatch},
+}
+
+func jwksOf(keys ...string) []byte {
+	return []byte(fmt.Sprintf(`{"keys":[%s]}`, strings.Join(keys, ",")))
+}
+
+func TestParseJWKSWithOptions(t *testing.T) {
+	jwks, err := ParseJWKSWithOptions(jwksOf(rsaKey, ecKey, ed25519Key), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(jwks.Keys) != 3 || len(jwks.Errors) != 0 {
+		t.Fatalf("expected 3 keys and no errors, got %d keys and errors %v", len(jwks.Keys), jwks.Errors)
+	}
+	for i, kid := range []string{"rsa", "ec", "ed"} {
+		if jwks.Keys[i].KeyID != kid {
+			t.Errorf("key %d: expected kid %s, got %s", i, kid, jwks.Keys[i].KeyID)
+		}
+	}
+}
+
+func TestParseJWKSWithOptionsMalformedDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  error
+	}{
+		{"not JSON", `{"keys":`, ErrMalformedJWKS},
+		{"null", `null`, ErrMalformedJWKS},
+		{"array", `[]`, ErrMalformedJWKS},
+		{"missing keys", `{}`, ErrMissingKeys},
+		{"keys not array", `{"keys":{}}`, ErrKeysNotArray},
+		{"null keys", `{"keys":null}`, ErrKeysNotArray},
+	}
+
+	for _, test := range tests {
+		for _, strict := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s strict=%t", test.name, strict), func(t *testing.T) {
+				_, err := ParseJWKSWithOptions([]byte(test.body), strict)
+				if !errors.Is(err, test.err) {
+					t.Errorf("expected %v, got %v", test.err, err)
+				}
+			})
+		}
+	}
+}
+
+func TestParseJWKSWithOptionsLenient(t *testing.T) {
+	for _, test := range invalidKeys {
+		t.Run(test.name, func(t *testing.T) {
+			jwks, err := ParseJWKSWithOptions(jwksOf(ecKey, test.key), false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "ec" {
+				t.Errorf("expected only the valid key, got %d keys", len(jwks.Keys))
+			}
+			if len(jwks.Errors) != 1 {
+				t.Fatalf("expected 1 key error, got %v", jwks.Errors)
+			}
+			if keyErr := jwks.Errors[0]; keyErr.Index != 1 || keyErr.Reason != test.reason {
+				t.Errorf("expected reason %q of key 1, got %q of key %d: %v", test.reason, keyErr.Reason, keyErr.Index, keyErr)
+			}
+		})
+	}
+}
+
+func TestParseJWKSWithOptionsStrict(t *testing.T) {
+	for _, test := range invalidKeys {
+		t.Run(test.name, func(t *testing.T) {
+			jwks, err := ParseJWKSWithOptions(jwksOf(ecKey, test.key), true)
+			if !errors.Is(err, ErrInvalidKeys) {
+				t.Fatalf("expected %v, got %v", ErrInvalidKeys, err)
+			}
+			if len(jwks.Keys) != 0 {
+				t.Errorf("expected the whole set to be rejected, got %d keys", len(jwks.Keys))
+			}
+
+			var keyErrs KeyErrors
+			if !errors.As(err, &keyErrs) || len(keyErrs) != 1 || keyErrs[0].Reason != test.reason {
+				t.Errorf("expected reason %q, got %v", test.reason, err)
+			}
+		})
+	}
+}
+
+func TestParseJWKSWithOptionsEncryptionKey(t *testing.T) {
+	// alg of encryption keys is a key management algorithm, which is not checked against the key
+	key := strings.Replace(strings.Replace(rsaKey, `"RS256"`, `"RSA-OAEP"`, 1), `"use":"sig"`, `"use":"enc"`, 1)
+
+	jwks, err := ParseJWKSWithOptions(jwksOf(key), true)
+	if err != nil || len(jwks.Keys) != 1 {
+		t.Errorf("expected the encryption key, got %d keys: %v", len(jwks.Keys), err)
+	}
+}
+
+func FuzzParseJWKSWithOptions(f *testing.F) {
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"keys":{}}`))
+	f.Add([]byte(`{"keys":"keys"}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte(`{"keys":null}`))
+	f.Add([]byte(`{"keys":[null, 1, "key", []]}`))
+	f.Add(jwksOf(rsaKey, ecKey, ed25519Key))
+	for _, test := range invalidKeys {
+		f.Add(jwksOf(ecKey, test.key))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		lenient, lenientErr := ParseJWKSWithOptions(body, false)
+		strict, strictErr := ParseJWKSWithOptions(body, true)
+
+		if lenientErr != nil {
+			// the document itself is malformed, regardless of the mode
+			if strictErr == nil || strictErr.Error() != lenientErr.Error() {
+				t.Fatalf("lenient error %v, but strict error %v", lenientErr, strictErr)
+			}
+			if len(lenient.Keys) != 0 || len(lenient.Errors) != 0 {
+				t.Fatalf("keys or key errors returned with error %v", lenientErr)
+			}
+			return
+		}
+
+		// members are matched case sensitively, unlike decoding into a struct
+		var document map[string]json.RawMessage
+		var keys []json.RawMessage
+		if err := json.Unmarshal(body, &document); err != nil {
+			t.Fatalf("parsed a JWKS which is not a JSON object: %v", err)
+		}
+		if err := json.Unmarshal(document["keys"], &keys); err != nil {
+			t.Fatalf("parsed a JWKS whose keys is not an array: %v", err)
+		}
+		if len(lenient.Keys)+len(lenient.Errors) != len(keys) {
+			t.Fatalf("%d keys and %d key errors of %d keys", len(lenient.Keys), len(lenient.Errors), len(keys))
+		}
+
+		kids := make(map[string]struct{})
+		for _, key := range lenient.Keys {
+			if key.KeyID == "" {
+				t.Fatalf("key without kid is returned")
+			}
+			if _, ok := kids[key.KeyID]; ok {
+				t.Fatalf("duplicate kid %s is returned", key.KeyID)
+			}
+			kids[key.KeyID] = struct{}{}
+		}
+		for _, keyErr := range lenient.Errors {
+			if keyErr.Reason == "" || keyErr.Index < 0 || keyErr.Index >= len(keys) {
+				t.Fatalf("invalid key error %#v", keyErr)
+			}
+		}
+
+		if len(lenient.Errors) == 0 {
+			if strictErr != nil || len(strict.Keys) != len(lenient.Keys) {
+				t.Fatalf("strict mode returned %d keys and %v for a valid JWKS", len(strict.Keys), strictErr)
+			}
+		} else if !errors.Is(strictErr, ErrInvalidKeys) || len(strict.Keys) != 0 {
+			t.Fatalf("strict mode returned %d keys and %v for a JWKS with invalid keys", len(strict.Keys), strictErr)
+		}
+	})
+}
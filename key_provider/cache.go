@@ -0,0 +1,90 @@
+package key_provider
+
+import (
+	"github.com/krafton-hq/oidc-discovery-server/jwt"
+	"sync"
+)
+
+// KeySetCache holds key sets shared by HTTPKeyProviders, so that an issuer trusted by several virtual issuers is fetched once.
+// key sets are keyed by canonical issuer and options, since key sets of different options are not interchangeable.
+// key sets are refreshed with the TTL bounds of the provider which finds them expired first.
+// a key set is removed once every provider holding it releases it.
+type KeySetCache struct {
+	lock    sync.Mutex
+	keySets map[keySetCacheKey]*keySetCacheEntry
+}
+
+type keySetCacheKey struct {
+	issuer  string
+	options jwt.KeySetOptions
+}
+
+type keySetCacheEntry struct {
+	keySet *jwt.CachedJsonWebKeySet
+	// refs is the number of providers holding keySet
+	refs int
+}
+
+func NewKeySetCache() *KeySetCache {
+	return &KeySetCache{
+		keySets: make(map[keySetCacheKey]*keySetCacheEntry),
+	}
+}
+
+// GetOrCreate returns the key set of canonical issuer with options, creating it for issuer if absent.
+// the caller holds the key set until it calls Release.
+func (cache *KeySetCache) GetOrCreate(canonical, issuer string, options jwt.KeySetOptions) *jwt.CachedJsonWebKeySet {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	key := keySetCacheKey{issuer: canonical, options: options}
+	entry, ok := cache.keySets[key]
+	if !ok {
+		entry = &keySetCacheEntry{keySet: jwt.NewCachedJsonWebKeySet(issuer, options)}
+		cache.keySets[key] = entry
+	}
+
+	entry.refs++
+	return entry.keySet
+}
+
+// Adopt puts keySet of canonical issuer unless the cache already has one with the same options, and returns the cached one.
+// the caller holds the returned key set until it calls Release.
+func (cache *KeySetCache) Adopt(canonical string, keySet *jwt.CachedJsonWebKeySet) *jwt.CachedJsonWebKeySet {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	key := keySetCacheKey{issuer: canonical, options: keySet.Options()}
+	entry, ok := cache.keySets[key]
+	if !ok {
+		entry = &keySetCacheEntry{keySet: keySet}
+		cache.keySets[key] = entry
+	}
+
+	entry.refs++
+	return entry.keySet
+}
+
+// Release drops a hold of keySet of canonical issuer, removing it from the cache if no provider holds it anymore
+func (cache *KeySetCache) Release(canonical string, keySet *jwt.CachedJsonWebKeySet) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	key := keySetCacheKey{issuer: canonical, options: keySet.Options()}
+	entry, ok := cache.keySets[key]
+	if !ok || entry.keySet != keySet {
+		return
+	}
+
+	entry.refs--
+	if entry.refs <= 0 {
+		delete(cache.keySets, key)
+	}
+}
+
+func (cache *KeySetCache) Len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	return len(cache.keySets)
+}
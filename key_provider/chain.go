@@ -2,10 +2,16 @@
 
 import (
 	"context"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
 	"github.com/zitadel/oidc/v2/pkg/op"
-	"go.uber.org/zap"
 )
 
+// CachedKeyProvider is a key provider which can return keys it already has without fetching any
+type CachedKeyProvider interface {
+	op.KeyProvider
+	CachedKeys(ctx context.Context) []op.Key
+}
+
 type ChainKeyProvider struct {
 	providers []op.KeyProvider
 }
@@ -16,6 +22,11 @@ func NewChainKeyProvider(providers ...op.KeyProvider) *ChainKeyProvider {
 	}
 }
 
+// Providers returns the chained providers in order
+func (c ChainKeyProvider) Providers() []op.KeyProvider {
+	return c.providers
+}
+
 func (c ChainKeyProvider) KeySet(ctx context.Context) ([]op.Key, error) {
 	var keys []op.Key
 	checked := make(map[string]struct{})
@@ -23,13 +34,13 @@ func (c ChainKeyProvider) KeySet(ctx context.Context) ([]op.Key, error) {
 	for _, provider := range c.providers {
 		keySet, err := provider.KeySet(ctx)
 		if err != nil {
-			zap.S().Warnf("error while getting keyset from provider: %s", err)
+			logging.FromContext(ctx).Warnf("error while getting keyset from provider: %s", err)
 			continue
 		}
 
 		for _, key := range keySet {
 			if _, ok := checked[key.ID()]; ok {
-				zap.S().Warnf("kid %s already exists. skipping.\n", key.ID())
+				logging.FromContext(ctx).Warnf("kid %s already exists. skipping.\n", key.ID())
 				continue
 			}
 
@@ -40,3 +51,27 @@ func (c ChainKeyProvider) KeySet(ctx context.Context) ([]op.Key, error) {
 
 	return keys, nil
 }
+
+// CachedKeys returns cached keys of every provider that caches keys, skipping duplicated kids like KeySet
+func (c ChainKeyProvider) CachedKeys(ctx context.Context) []op.Key {
+	keys := make([]op.Key, 0)
+	checked := make(map[string]struct{})
+
+	for _, provider := range c.providers {
+		cached, ok := provider.(CachedKeyProvider)
+		if !ok {
+			continue
+		}
+
+		for _, key := range cached.CachedKeys(ctx) {
+			if _, ok := checked[key.ID()]; ok {
+				continue
+			}
+
+			checked[key.ID()] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
@@ -5,6 +5,7 @@
 	"github.com/fanliao/go-promise"
 	"github.com/krafton-hq/oidc-discovery-server/issuer_provider"
 	"github.com/krafton-hq/oidc-discovery-server/jwt"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
 	"github.com/krafton-hq/oidc-discovery-server/util/perf"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"github.com/pkg/errors"
@@ -12,6 +13,7 @@
 	"github.com/zitadel/oidc/v2/pkg/op"
 	"go.uber.org/zap"
 	"net/http"
+	"sort"
 	"time"
 )
 
@@ -19,26 +21,107 @@ type HTTPKeyProvider struct {
 	client         *http.Client
 	config         *viper.Viper
 	issuerProvider issuer_provider.IssuerProvider
-	cachedKeySets  cmap.ConcurrentMap[string, *jwt.CachedJsonWebKeySet]
+	normalizer     issuer_provider.IssuerNormalizer
+	// cachedKeySets and issuerOptions are keyed by canonical issuer.
+	// cachedKeySets holds key sets looked up by this provider, which may be shared with other providers through cache.
+	cachedKeySets cmap.ConcurrentMap[string, *jwt.CachedJsonWebKeySet]
+	cache         *KeySetCache
+	issuerOptions map[string]IssuerOptions
 }
 
-func NewHTTPKeyProvider(issuerProvider issuer_provider.IssuerProvider, config *viper.Viper) *HTTPKeyProvider {
+// IssuerOptions overrides key provider settings for a single issuer
+type IssuerOptions struct {
+	Issuer string `mapstructure:"issuer"`
+	// AllowIssuerMismatch accepts a discovery document whose issuer differs from the configured one.
+	// only for known-nonconformant providers, e.g. EKS API server discovery.
+	AllowIssuerMismatch bool `mapstructure:"allowIssuerMismatch"`
+	// MetadataPath is the well-known metadata path, e.g. /.well-known/oauth-authorization-server for RFC 8414 servers
+	MetadataPath string `mapstructure:"metadataPath"`
+	// MetadataPathStyle is either "append" (OpenID Connect Discovery) or "insert" (RFC 8414).
+	// defaults to "append" for the OpenID configuration path and "insert" otherwise.
+	MetadataPathStyle string `mapstructure:"metadataPathStyle"`
+	// StrictKeys overrides strictKeys of the key provider for this issuer
+	StrictKeys *bool `mapstructure:"strictKeys"`
+}
+
+const (
+	MetadataPathStyleAppend = "append"
+	MetadataPathStyleInsert = "insert"
+)
+
+func (options IssuerOptions) Validate() error {
+	if options.Issuer == "" {
+		return errors.New("issuer is required")
+	}
+
+	switch options.MetadataPathStyle {
+	case "", MetadataPathStyleAppend, MetadataPathStyleInsert:
+	default:
+		return errors.Errorf("unknown metadataPathStyle %s. must be one of %s, %s", options.MetadataPathStyle, MetadataPathStyleAppend, MetadataPathStyleInsert)
+	}
+
+	return nil
+}
+
+func (options IssuerOptions) metadataPathInsertion() bool {
+	switch options.MetadataPathStyle {
+	case MetadataPathStyleAppend:
+		return false
+	case MetadataPathStyleInsert:
+		return true
+	default:
+		return options.MetadataPath != "" && options.MetadataPath != jwt.OIDCDocumentPath
+	}
+}
+
+// NewHTTPKeyProvider creates a key provider sharing key sets through cache. a nil cache is not shared.
+func NewHTTPKeyProvider(issuerProvider issuer_provider.IssuerProvider, normalizer issuer_provider.IssuerNormalizer, cache *KeySetCache, config *viper.Viper) *HTTPKeyProvider {
+	if cache == nil {
+		cache = NewKeySetCache()
+	}
+
 	if config == nil {
 		config = viper.New()
 		// TODO: remove magic strings
 		config.Set("maxTTLSeconds", 300)
 	}
 
+	config.SetDefault("minTTLSeconds", 10)
+	config.SetDefault("strictIssuer", true)
+	config.SetDefault("discovery.defaultTTLSeconds", 3600)
+	config.SetDefault("discovery.maxTTLSeconds", 86400)
+
+	defaultBackoff := jwt.DefaultBackoffPolicy()
+	config.SetDefault("backoff.initialSeconds", defaultBackoff.InitialInterval.Seconds())
+	config.SetDefault("backoff.maxSeconds", defaultBackoff.MaxInterval.Seconds())
+	config.SetDefault("backoff.multiplier", defaultBackoff.Multiplier)
+	config.SetDefault("backoff.jitter", defaultBackoff.Jitter)
+	config.SetDefault("circuitBreaker.failureThreshold", defaultBackoff.FailureThreshold)
+	config.SetDefault("circuitBreaker.openSeconds", defaultBackoff.OpenDuration.Seconds())
+
+	var issuerOptions []IssuerOptions
+	if err := config.UnmarshalKey("issuers", &issuerOptions); err != nil {
+		zap.S().Errorf("failed to parse per-issuer options. ignoring. %v", err)
+	}
+
+	issuerOptionsMap := make(map[string]IssuerOptions, len(issuerOptions))
+	for _, options := range issuerOptions {
+		issuerOptionsMap[normalizer.Canonical(options.Issuer)] = options
+	}
+
 	return &HTTPKeyProvider{
 		client:         http.DefaultClient,
 		config:         config,
 		issuerProvider: issuerProvider,
+		normalizer:     normalizer,
 		cachedKeySets:  cmap.New[*jwt.CachedJsonWebKeySet](),
+		cache:          cache,
+		issuerOptions:  issuerOptionsMap,
 	}
 }
 
 func (provider *HTTPKeyProvider) KeysInCache(issuer string) (*jwt.CachedJsonWebKeySet, bool) {
-	keySet, exists := provider.cachedKeySets.Get(issuer)
+	keySet, exists := provider.cachedKeySets.Get(provider.normalizer.Canonical(issuer))
 
 	// copy to avoid modifying keySet in outside
 	return &*keySet, exists
@@ -51,32 +134,42 @@ func (provider *HTTPKeyProvider) KeySet(ctx context.Context) ([]op.Key, error) {
 	reachedIssuers := cmap.New[struct{}]()
 	promises := make([]interface{}, 0)
 
-	for _, issuer := range provider.issuerProvider.Issuers() {
+	issuers, err := provider.issuerProvider.Issuers(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("error while getting issuers. continuing with %d resolved issuers: %v\n", len(issuers), err)
+	} else {
+		provider.evictUntrusted(ctx, issuers)
+	}
+
+	for _, issuer := range issuers {
 		p := promise.NewPromise()
 		promises = append(promises, p)
 		issuer := issuer
 
 		go func() {
 			keys := make([]op.Key, 0)
-			zap.S().Infof("lookup issuer: %s\n", issuer)
+			logging.FromContext(ctx).Infof("lookup issuer: %s\n", issuer.URL)
 
-			if reachedIssuers.SetIfAbsent(issuer, struct{}{}) {
-				keySet, err := provider.GetKeySetFromIssuer(ctx, issuer, false)
-				if err != nil {
-					zap.S().Warnf("Error getting KeySet from issuer %s: %+v\n", issuer, err)
+			if reachedIssuers.SetIfAbsent(provider.normalizer.Canonical(issuer.URL), struct{}{}) {
+				keySet, err := provider.GetKeySet(ctx, issuer, false)
+				var backoffErr *jwt.BackoffError
+				if errors.As(err, &backoffErr) {
+					logging.FromContext(ctx).Debugf("Issuer %s is backing off: %v\n", issuer.URL, err)
+				} else if err != nil {
+					logging.FromContext(ctx).Warnf("Error getting KeySet from issuer %s: %+v\n", issuer.URL, err)
 				} else {
 					for _, key := range keySet.Keys() {
-						zap.S().Debugf("key: %s\n", key.ID())
+						logging.FromContext(ctx).Debugf("key: %s\n", key.ID())
 						keys = append(keys, key)
 					}
 				}
 			} else {
-				zap.S().Warnf("Issuer %s already reached. Skipping.\n", issuer)
+				logging.FromContext(ctx).Warnf("Issuer %s already reached. Skipping.\n", issuer.URL)
 			}
 
-			zap.S().Infof("resolved %d keys.\n", len(keys))
+			logging.FromContext(ctx).Infof("resolved %d keys.\n", len(keys))
 			if err := p.Resolve(keys); err != nil {
-				zap.S().Error(err)
+				logging.FromContext(ctx).Error(err)
 			}
 		}()
 	}
@@ -93,13 +186,13 @@ func (provider *HTTPKeyProvider) KeySet(ctx context.Context) ([]op.Key, error) {
 	for _, value := range values.([]interface{}) {
 		keys, err := value.(*promise.Promise).Get()
 		if err != nil {
-			zap.S().Error(err)
+			logging.FromContext(ctx).Error(err)
 			continue
 		}
 
 		for _, key := range keys.([]op.Key) {
 			if _, ok := checked[key.ID()]; ok {
-				zap.S().Warnf("kid %s already exists. skipping.\n", key.ID())
+				logging.FromContext(ctx).Warnf("kid %s already exists. skipping.\n", key.ID())
 				continue
 			}
 
@@ -111,40 +204,278 @@ func (provider *HTTPKeyProvider) KeySet(ctx context.Context) ([]op.Key, error) {
 	return result, nil
 }
 
+// CachedKeys returns keys of the key sets of trusted issuers looked up so far, without fetching any issuer.
+// key sets of issuers no longer trusted are evicted.
+func (provider *HTTPKeyProvider) CachedKeys(ctx context.Context) []op.Key {
+	issuers, err := provider.issuerProvider.Issuers(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("error while getting issuers. continuing with %d resolved issuers: %v\n", len(issuers), err)
+	} else {
+		provider.evictUntrusted(ctx, issuers)
+	}
+
+	keys := make([]op.Key, 0)
+	reached := make(map[string]struct{}, len(issuers))
+	for _, issuer := range issuers {
+		canonical := provider.normalizer.Canonical(issuer.URL)
+		if _, ok := reached[canonical]; ok {
+			continue
+		}
+		reached[canonical] = struct{}{}
+
+		if keySet, exists := provider.cachedKeySets.Get(canonical); exists {
+			keys = append(keys, keySet.Keys()...)
+		}
+	}
+
+	return keys
+}
+
+// evictUntrusted drops cached key sets of issuers not in trusted, so that they are neither served nor kept in the shared cache.
+// trusted must be the complete list of the issuer provider, since every other key set is evicted.
+func (provider *HTTPKeyProvider) evictUntrusted(ctx context.Context, trusted []issuer_provider.Issuer) {
+	canonicals := make(map[string]struct{}, len(trusted))
+	for _, issuer := range trusted {
+		canonicals[provider.normalizer.Canonical(issuer.URL)] = struct{}{}
+	}
+
+	for _, issuer := range provider.cachedKeySets.Keys() {
+		if _, ok := canonicals[issuer]; ok {
+			continue
+		}
+
+		if keySet, exists := provider.cachedKeySets.Pop(issuer); exists {
+			provider.cache.Release(issuer, keySet)
+			logging.FromContext(ctx).Infof("evicted key set of issuer no longer trusted: %s\n", keySet.Issuer())
+		}
+	}
+}
+
+// Close releases every key set held by the provider from the shared cache
+func (provider *HTTPKeyProvider) Close() {
+	for _, issuer := range provider.cachedKeySets.Keys() {
+		if keySet, exists := provider.cachedKeySets.Pop(issuer); exists {
+			provider.cache.Release(issuer, keySet)
+		}
+	}
+}
+
+// Spellings returns issuer and every spelling of its canonical issuer given by the issuer provider.
+// a discovery document whose issuer is any of them is accepted, as the key set records them by cachedKeySet.
+func (provider *HTTPKeyProvider) Spellings(ctx context.Context, issuer string) []string {
+	issuers, err := provider.issuerProvider.Issuers(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("error while getting issuers. continuing with %d resolved issuers: %v\n", len(issuers), err)
+	}
+
+	spellings := []string{issuer}
+	found := map[string]struct{}{issuer: {}}
+	canonical := provider.normalizer.Canonical(issuer)
+	for _, trusted := range issuers {
+		if provider.normalizer.Canonical(trusted.URL) != canonical {
+			continue
+		}
+
+		for _, spelling := range append([]string{trusted.URL}, trusted.Aliases...) {
+			if _, ok := found[spelling]; !ok {
+				found[spelling] = struct{}{}
+				spellings = append(spellings, spelling)
+			}
+		}
+	}
+
+	return spellings
+}
+
+// ErrUntrustedIssuer is returned by GetKeySetFromIssuer for issuers not returned by the issuer provider
+var ErrUntrustedIssuer = errors.New("issuer is not trusted")
+
+// GetKeySetFromIssuer returns the key set of issuer, which must be one of the trusted issuers of the issuer provider,
+// so that callers can neither make requests to arbitrary URLs nor grow the key set cache without bound.
 func (provider *HTTPKeyProvider) GetKeySetFromIssuer(ctx context.Context, issuer string, force bool) (*jwt.CachedJsonWebKeySet, error) {
-	defaultKeyTTL := time.Duration(provider.GetDefaultKeyTTLSeconds()) * time.Second
-	maxKeyTTL := time.Duration(provider.MaxTTLSeconds()) * time.Second
+	issuers, err := provider.issuerProvider.Issuers(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("error while getting issuers. continuing with %d resolved issuers: %v\n", len(issuers), err)
+	}
 
-	// NOTE: 쓸데없이 객체 생성하긴 하는데 성능 필요한 코드 아니라서 괜찮을 듯
-	keySet := jwt.NewCachedJsonWebKeySet(issuer)
-	if !provider.cachedKeySets.SetIfAbsent(issuer, keySet) {
-		var exists bool
-		keySet, exists = provider.cachedKeySets.Get(issuer)
-		if !exists {
-			panic("key set not exists")
+	canonical := provider.normalizer.Canonical(issuer)
+	for _, trusted := range issuers {
+		if provider.normalizer.Canonical(trusted.URL) == canonical {
+			return provider.GetKeySet(ctx, trusted, force)
 		}
-	} else {
-		zap.S().Debugf("key set not exists. created new one: %v\n", keySet)
 	}
 
-	if keySet.ShouldRefresh(time.Now()) {
-		zap.S().Infof("keyset expired. issuer: %v\n", keySet.Issuer())
+	return nil, errors.Wrapf(ErrUntrustedIssuer, "issuer: %s", issuer)
+}
+
+// GetKeySet returns the key set of issuer. the TTL given by the issuer provider, if any, caps the key cache TTL.
+func (provider *HTTPKeyProvider) GetKeySet(ctx context.Context, issuerInfo issuer_provider.Issuer, force bool) (*jwt.CachedJsonWebKeySet, error) {
+	minTTL := time.Duration(provider.MinTTLSeconds()) * time.Second
+	keyPolicy := jwt.CachePolicy{
+		DefaultTTL: time.Duration(provider.GetDefaultKeyTTLSeconds()) * time.Second,
+		MaxTTL:     time.Duration(provider.MaxTTLSeconds()) * time.Second,
+		MinTTL:     minTTL,
+	}
+	if issuerTTL := time.Duration(issuerInfo.TTLSeconds) * time.Second; issuerTTL > 0 && issuerTTL < keyPolicy.MaxTTL {
+		keyPolicy.MaxTTL = issuerTTL
+		if keyPolicy.DefaultTTL > issuerTTL {
+			keyPolicy.DefaultTTL = issuerTTL
+		}
+	}
+	discoveryPolicy := jwt.CachePolicy{
+		DefaultTTL: time.Duration(provider.config.GetInt("discovery.defaultTTLSeconds")) * time.Second,
+		MaxTTL:     time.Duration(provider.config.GetInt("discovery.maxTTLSeconds")) * time.Second,
+		MinTTL:     minTTL,
+	}
+
+	keySet := provider.cachedKeySet(ctx, issuerInfo)
+
+	if now := time.Now(); keySet.ShouldRefresh(now) {
+		logging.FromContext(ctx).Infof("keyset expired. issuer: %v\n", keySet.Issuer())
+
+		if !force && keySet.CanServeStale(now) {
+			logging.FromContext(ctx).Debugf("serving stale keyset while revalidating. issuer: %v\n", keySet.Issuer())
+			keySet.UpdateInBackground(ctx, provider.client, keyPolicy, discoveryPolicy)
+			return keySet, nil
+		}
 
-		err := keySet.Update(ctx, provider.client, defaultKeyTTL, maxKeyTTL, force)
+		err := keySet.Update(ctx, provider.client, keyPolicy, discoveryPolicy, force)
 		if err != nil {
+			if keySet.CanServeStaleOnError(now) {
+				logging.FromContext(ctx).Warnf("serving stale keyset on error. issuer: %v. %v\n", keySet.Issuer(), err)
+				return keySet, nil
+			}
+
 			return nil, err
 		}
 	} else {
-		zap.S().Debugln("keyset not expired. skipping update.")
+		logging.FromContext(ctx).Debugln("keyset not expired. skipping update.")
 	}
 
 	return keySet, nil
 }
 
+// cachedKeySet returns the key set of the canonical issuer of issuerInfo, and records its URL and aliases as spellings.
+// the first exact issuer string of a canonical issuer is used for fetching.
+// the discovered issuer is validated against every spelling, so the result doesn't depend on which is looked up first.
+func (provider *HTTPKeyProvider) cachedKeySet(ctx context.Context, issuerInfo issuer_provider.Issuer) *jwt.CachedJsonWebKeySet {
+	issuer := issuerInfo.URL
+	canonical := provider.normalizer.Canonical(issuer)
+	keySet, exists := provider.cachedKeySets.Get(canonical)
+	if !exists {
+		keySet = provider.cache.GetOrCreate(canonical, issuer, provider.KeySetOptions(issuer))
+		if !provider.cachedKeySets.SetIfAbsent(canonical, keySet) {
+			// another request looked it up concurrently
+			provider.cache.Release(canonical, keySet)
+			if current, ok := provider.cachedKeySets.Get(canonical); ok {
+				keySet = current
+			}
+		}
+		logging.FromContext(ctx).Debugf("key set not looked up yet. using shared one: %v\n", keySet.Issuer())
+	}
+	keySet.AddSpelling(issuer)
+	for _, alias := range issuerInfo.Aliases {
+		keySet.AddSpelling(alias)
+	}
+
+	return keySet
+}
+
+// AdoptKeySets takes over cached key sets of old provider whose options are unchanged,
+// so that replacing the provider on config change does not refetch every issuer
+func (provider *HTTPKeyProvider) AdoptKeySets(old *HTTPKeyProvider) {
+	adopted := 0
+	for issuer, keySet := range old.cachedKeySets.Items() {
+		if keySet.Options() != provider.KeySetOptions(issuer) {
+			continue
+		}
+
+		adoptedKeySet := provider.cache.Adopt(issuer, keySet)
+		if provider.cachedKeySets.SetIfAbsent(issuer, adoptedKeySet) {
+			adopted++
+		} else {
+			provider.cache.Release(issuer, adoptedKeySet)
+		}
+	}
+
+	zap.S().Infof("adopted %d of %d cached key sets\n", adopted, old.cachedKeySets.Count())
+}
+
+// Status returns status of every issuer that has been looked up so far, sorted by issuer
+func (provider *HTTPKeyProvider) Status() []jwt.KeySetStatus {
+	statuses := make([]jwt.KeySetStatus, 0, provider.cachedKeySets.Count())
+	for _, keySet := range provider.cachedKeySets.Items() {
+		statuses = append(statuses, keySet.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Issuer < statuses[j].Issuer
+	})
+
+	return statuses
+}
+
+// KeySetStatus returns status of the issuer, false if the issuer has not been looked up yet
+func (provider *HTTPKeyProvider) KeySetStatus(issuer string) (jwt.KeySetStatus, bool) {
+	keySet, exists := provider.cachedKeySets.Get(provider.normalizer.Canonical(issuer))
+	if !exists {
+		return jwt.KeySetStatus{}, false
+	}
+
+	return keySet.Status(), true
+}
+
+func (provider *HTTPKeyProvider) KeySetOptions(issuer string) jwt.KeySetOptions {
+	options := provider.issuerOptions[provider.normalizer.Canonical(issuer)]
+
+	return jwt.KeySetOptions{
+		Backoff:      provider.BackoffPolicy(),
+		StrictIssuer: provider.StrictIssuer() && !options.AllowIssuerMismatch,
+
+		MetadataPath:          options.MetadataPath,
+		MetadataPathInsertion: options.metadataPathInsertion(),
+
+		StrictKeys: provider.StrictKeys(options),
+	}
+}
+
+func (provider *HTTPKeyProvider) StrictIssuer() bool {
+	return provider.config.GetBool("strictIssuer")
+}
+
+// StrictKeys returns whether a key set with any invalid key is rejected as a whole for an issuer of options
+func (provider *HTTPKeyProvider) StrictKeys(options IssuerOptions) bool {
+	if options.StrictKeys != nil {
+		return *options.StrictKeys
+	}
+
+	return provider.config.GetBool("strictKeys")
+}
+
+func (provider *HTTPKeyProvider) BackoffPolicy() jwt.BackoffPolicy {
+	return jwt.BackoffPolicy{
+		InitialInterval:  secondsToDuration(provider.config.GetFloat64("backoff.initialSeconds")),
+		MaxInterval:      secondsToDuration(provider.config.GetFloat64("backoff.maxSeconds")),
+		Multiplier:       provider.config.GetFloat64("backoff.multiplier"),
+		Jitter:           provider.config.GetFloat64("backoff.jitter"),
+		FailureThreshold: provider.config.GetInt("circuitBreaker.failureThreshold"),
+		OpenDuration:     secondsToDuration(provider.config.GetFloat64("circuitBreaker.openSeconds")),
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 func (provider *HTTPKeyProvider) MaxTTLSeconds() int {
 	return provider.config.GetInt("maxTTLSeconds")
 }
 
+// MinTTLSeconds is the floor of key set and discovery document TTLs
+func (provider *HTTPKeyProvider) MinTTLSeconds() int {
+	return provider.config.GetInt("minTTLSeconds")
+}
+
 func (provider *HTTPKeyProvider) GetDefaultKeyTTLSeconds() int {
 	return provider.config.GetInt("defaultKeyTTLSeconds")
 }
@@ -0,0 +1,57 @@
+package key_provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krafton-hq/oidc-discovery-server/issuer_provider"
+)
+
+type fixedIssuers []issuer_provider.Issuer
+
+func (issuers *fixedIssuers) Issuers(ctx context.Context) ([]issuer_provider.Issuer, error) {
+	return *issuers, nil
+}
+
+func TestCachedKeysEvictsUntrustedIssuers(t *testing.T) {
+	normalizer, err := issuer_provider.NewIssuerNormalizer(nil)
+	if err != nil {
+		t.Fatalf("failed to create normalizer: %v", err)
+	}
+
+	issuers := &fixedIssuers{{URL: "https://a.example.com"}, {URL: "https://b.example.com"}}
+	cache := NewKeySetCache()
+	first := NewHTTPKeyProvider(issuers, normalizer, cache, nil)
+	second := NewHTTPKeyProvider(issuers, normalizer, cache, nil)
+
+	ctx := context.Background()
+	for _, issuer := range *issuers {
+		first.cachedKeySet(ctx, issuer)
+	}
+	second.cachedKeySet(ctx, (*issuers)[0])
+	if cache.Len() != 2 {
+		t.Fatalf("expected 2 shared key sets, got %d", cache.Len())
+	}
+
+	*issuers = (*issuers)[1:]
+	first.CachedKeys(ctx)
+	if _, exists := first.KeySetStatus("https://a.example.com"); exists {
+		t.Errorf("key set of untrusted issuer is not evicted")
+	}
+	if _, exists := first.KeySetStatus("https://b.example.com"); !exists {
+		t.Errorf("key set of trusted issuer is evicted")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("key set held by another provider is removed from the cache. %d key sets left", cache.Len())
+	}
+
+	second.CachedKeys(ctx)
+	if cache.Len() != 1 {
+		t.Errorf("expected 1 key set left in the cache, got %d", cache.Len())
+	}
+
+	first.Close()
+	if cache.Len() != 0 {
+		t.Errorf("expected no key set left after close, got %d", cache.Len())
+	}
+}
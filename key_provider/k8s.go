@@ -7,12 +7,15 @@
 	"github.com/zitadel/oidc/v2/pkg/op"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"sync"
 	"time"
 )
 
 // TODO: out-cluster support?
 type K8SKeyProvider struct {
-	client  *kubernetes.Clientset
+	client *kubernetes.Clientset
+
+	lock    sync.RWMutex
 	keys    []op.Key
 	expires time.Time
 }
@@ -29,12 +32,15 @@ func NewK8SKeyProvider() (*K8SKeyProvider, error) {
 	}
 
 	return &K8SKeyProvider{
-		clientSet,
-		nil,
-		time.Now(),
+		client:  clientSet,
+		expires: time.Now(),
 	}, nil
 }
 
+func (provider *K8SKeyProvider) String() string {
+	return "k8s in-cluster"
+}
+
 func (provider *K8SKeyProvider) KeySet(ctx context.Context) ([]op.Key, error) {
 	if provider.Expires(time.Now()) {
 		err := provider.update(ctx)
@@ -43,7 +49,15 @@ func (provider *K8SKeyProvider) KeySet(ctx context.Context) ([]op.Key, error) {
 		}
 	}
 
-	return provider.keys, nil
+	return provider.CachedKeys(ctx), nil
+}
+
+// CachedKeys returns keys fetched by the last update, without fetching
+func (provider *K8SKeyProvider) CachedKeys(ctx context.Context) []op.Key {
+	provider.lock.RLock()
+	defer provider.lock.RUnlock()
+
+	return provider.keys
 }
 
 func (provider *K8SKeyProvider) update(ctx context.Context) error {
@@ -62,6 +76,9 @@ func (provider *K8SKeyProvider) update(ctx context.Context) error {
 		keys2[i] = &jwt.JsonWebKey{JSONWebKey: key}
 	}
 
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
 	provider.keys = keys2
 	provider.expires = time.Now().Add(60 * time.Second)
 
@@ -69,5 +86,8 @@ func (provider *K8SKeyProvider) update(ctx context.Context) error {
 }
 
 func (provider *K8SKeyProvider) Expires(now time.Time) bool {
+	provider.lock.RLock()
+	defer provider.lock.RUnlock()
+
 	return provider.expires.Before(now)
 }
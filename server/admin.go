@@ -0,0 +1,104 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/krafton-hq/oidc-discovery-server/jwt"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+)
+
+const MetricsPath = "/debug/vars"
+const PprofPath = "/debug/pprof/"
+
+var publishMetrics sync.Once
+
+// AdminHandler registers operational endpoints on router, which is meant to be served by a listener unreachable from the internet:
+// status and readiness of every virtual issuer of issuers, keys of each trusted issuer, log level, expvar metrics and pprof.
+func AdminHandler(router *mux.Router, issuers *IssuerRouter) {
+	router.HandleFunc(StatusPath, func(w http.ResponseWriter, r *http.Request) {
+		status := make(map[string]interface{})
+		for _, virtualIssuer := range issuers.Get() {
+			status[virtualIssuer.Name] = providersStatus(r.Context(), virtualIssuer.Providers.Get())
+		}
+
+		writeJSON(w, status)
+	})
+
+	// ready only if every virtual issuer is ready
+	router.HandleFunc(ReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		for _, virtualIssuer := range issuers.Get() {
+			if err := providersReady(r.Context(), virtualIssuer.Providers.Get()); err != nil {
+				writeReadiness(w, r, errors.Wrapf(err, "%s is not ready", virtualIssuer.Name))
+				return
+			}
+		}
+
+		writeReadiness(w, r, nil)
+	})
+
+	// the virtualIssuer query parameter selects the virtual issuer, the first one if empty
+	router.HandleFunc(KeysIssuerPath, func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("virtualIssuer")
+		for _, virtualIssuer := range issuers.Get() {
+			if name == "" || virtualIssuer.Name == name {
+				writeIssuerKeys(w, r, virtualIssuer.Providers.Get())
+				return
+			}
+		}
+
+		http.Error(w, fmt.Sprintf("virtual issuer %s not found", name), http.StatusNotFound)
+	})
+
+	LogLevelHandler(router)
+
+	// expvar vars are global, so issuers of the first admin handler are published
+	publishMetrics.Do(func() {
+		expvar.Publish("virtualIssuers", expvar.Func(func() interface{} {
+			return virtualIssuerMetrics(issuers)
+		}))
+	})
+	router.Handle(MetricsPath, expvar.Handler())
+
+	router.HandleFunc(PprofPath+"cmdline", pprof.Cmdline)
+	router.HandleFunc(PprofPath+"profile", pprof.Profile)
+	router.HandleFunc(PprofPath+"symbol", pprof.Symbol)
+	router.HandleFunc(PprofPath+"trace", pprof.Trace)
+	router.PathPrefix(PprofPath).HandlerFunc(pprof.Index)
+}
+
+// VirtualIssuerMetrics is a summary of key sets of a virtual issuer
+type VirtualIssuerMetrics struct {
+	KeySets         int `json:"keySets"`
+	Keys            int `json:"keys"`
+	FailingKeySets  int `json:"failingKeySets"`
+	OpenCircuits    int `json:"openCircuits"`
+	RejectedIssuers int `json:"rejectedIssuers"`
+}
+
+func virtualIssuerMetrics(issuers *IssuerRouter) map[string]VirtualIssuerMetrics {
+	metrics := make(map[string]VirtualIssuerMetrics)
+	for _, virtualIssuer := range issuers.Get() {
+		providers := virtualIssuer.Providers.Get()
+
+		var issuerMetrics VirtualIssuerMetrics
+		for _, status := range providers.HTTPKeyProvider.Status() {
+			issuerMetrics.KeySets++
+			issuerMetrics.Keys += status.KeyCount
+			if status.ConsecutiveFailures > 0 {
+				issuerMetrics.FailingKeySets++
+			}
+			if status.Circuit == jwt.CircuitOpen {
+				issuerMetrics.OpenCircuits++
+			}
+		}
+		issuerMetrics.RejectedIssuers = len(providers.IssuerPolicy.Rejected())
+
+		metrics[virtualIssuer.Name] = issuerMetrics
+	}
+
+	return metrics
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	ForwardedProtoHeader  = "X-Forwarded-Proto"
+	ForwardedHostHeader   = "X-Forwarded-Host"
+	ForwardedPrefixHeader = "X-Forwarded-Prefix"
+)
+
+// forwardedVary lists the headers responses depend on if ForwardedMiddleware is used
+var forwardedVary = strings.Join([]string{ForwardedProtoHeader, ForwardedHostHeader, ForwardedPrefixHeader}, ", ")
+
+type forwardedKey struct{}
+
+// ForwardedConfig restricts whose X-Forwarded-* headers are used
+type ForwardedConfig struct {
+	// TrustedProxies are CIDRs or IPs of proxies overwriting X-Forwarded-* headers. headers of requests from other addresses are ignored.
+	TrustedProxies []string `mapstructure:"trustedProxies"`
+	// AllowedHosts are hosts X-Forwarded-Host may be, e.g. public.example.com. empty allows any host.
+	AllowedHosts []string `mapstructure:"allowedHosts"`
+}
+
+func (config ForwardedConfig) Validate() error {
+	if len(config.TrustedProxies) == 0 {
+		return errors.New("trustedProxies is required")
+	}
+
+	_, err := parseTrustedProxies(config.TrustedProxies)
+	return err
+}
+
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.Errorf("invalid trusted proxy %s. must be an IP or a CIDR", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid trusted proxy %s", proxy)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Forwarded is how the client reached this server through reverse proxies. empty fields are not forwarded.
+type Forwarded struct {
+	Proto  string
+	Host   string
+	Prefix string
+}
+
+// ForwardedMiddleware puts X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix into the request context,
+// so that the issuer and jwks_uri are derived from them. the headers are used only if the request comes from a trusted proxy,
+// since otherwise any client can make this server advertise an arbitrary issuer.
+// responses vary by the headers, so that caches between the proxy and this server don't mix them up.
+func ForwardedMiddleware(config ForwardedConfig) (func(http.Handler) http.Handler, error) {
+	trustedProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", forwardedVary)
+
+			forwarded := Forwarded{}
+			if isTrustedProxy(r, trustedProxies) {
+				forwarded = forwardedOf(r, config.AllowedHosts)
+			} else if hasForwardedHeaders(r) {
+				logging.FromContext(r.Context()).Debugf("ignoring forwarded headers of untrusted client %s\n", r.RemoteAddr)
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), forwardedKey{}, forwarded)))
+		})
+	}, nil
+}
+
+func forwardedOf(r *http.Request, allowedHosts []string) Forwarded {
+	forwarded := Forwarded{
+		Proto:  strings.ToLower(firstHeaderValue(r, ForwardedProtoHeader)),
+		Host:   firstHeaderValue(r, ForwardedHostHeader),
+		Prefix: strings.TrimSuffix(firstHeaderValue(r, ForwardedPrefixHeader), "/"),
+	}
+
+	// ignore values which can't be a part of an URL rather than serving a broken issuer
+	if forwarded.Proto != "http" && forwarded.Proto != "https" {
+		forwarded.Proto = ""
+	}
+	if strings.ContainsAny(forwarded.Host, "/?#@ ") {
+		forwarded.Host = ""
+	}
+	if forwarded.Host != "" && !isAllowedHost(forwarded.Host, allowedHosts) {
+		logging.FromContext(r.Context()).Debugf("ignoring forwarded host %s not in allowed hosts\n", forwarded.Host)
+		forwarded.Host = ""
+	}
+	if forwarded.Prefix != "" && (!strings.HasPrefix(forwarded.Prefix, "/") || strings.ContainsAny(forwarded.Prefix, "?# ")) {
+		forwarded.Prefix = ""
+	}
+
+	return forwarded
+}
+
+// isTrustedProxy returns whether the peer of r is one of trustedProxies
+func isTrustedProxy(r *http.Request, trustedProxies []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAllowedHost returns whether host, with or without port, is one of allowedHosts. empty allowedHosts allows any host.
+func isAllowedHost(host string, allowedHosts []string) bool {
+	if len(allowedHosts) == 0 {
+		return true
+	}
+
+	hostname := host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		hostname = name
+	}
+
+	for _, allowed := range allowedHosts {
+		if strings.EqualFold(host, allowed) || strings.EqualFold(hostname, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasForwardedHeaders(r *http.Request) bool {
+	return r.Header.Get(ForwardedProtoHeader) != "" || r.Header.Get(ForwardedHostHeader) != "" || r.Header.Get(ForwardedPrefixHeader) != ""
+}
+
+// ForwardedFromContext returns Forwarded of ctx, false if ForwardedMiddleware is not used
+func ForwardedFromContext(ctx context.Context) (Forwarded, bool) {
+	forwarded, ok := ctx.Value(forwardedKey{}).(Forwarded)
+	return forwarded, ok
+}
+
+// ExternalIssuer returns issuer as seen by the client of ctx: scheme, host and path prefix of issuer are replaced by forwarded ones
+func ExternalIssuer(ctx context.Context, issuer string) string {
+	forwarded, ok := ForwardedFromContext(ctx)
+	if !ok || forwarded == (Forwarded{}) {
+		return issuer
+	}
+
+	parsed, err := url.Parse(issuer)
+	if err != nil {
+		return issuer
+	}
+
+	if forwarded.Proto != "" {
+		parsed.Scheme = forwarded.Proto
+	}
+	if forwarded.Host != "" {
+		parsed.Host = forwarded.Host
+	}
+	if forwarded.Prefix != "" {
+		parsed.Path = forwarded.Prefix + parsed.Path
+		parsed.RawPath = ""
+	}
+
+	return parsed.String()
+}
+
+// firstHeaderValue returns the value set by the proxy closest to the client, if several proxies appended theirs
+func firstHeaderValue(r *http.Request, header string) string {
+	value, _, _ := strings.Cut(r.Header.Get(header), ",")
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedMiddlewareTrustedProxies(t *testing.T) {
+	middleware, err := ForwardedMiddleware(ForwardedConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"},
+		AllowedHosts:   []string{"public.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		host       string
+		expected   string
+	}{
+		{"trusted CIDR", "10.1.2.3:1234", "public.example.com", "https://public.example.com/auth/oidc"},
+		{"trusted IP", "192.0.2.1:1234", "public.example.com:8443", "https://public.example.com:8443/auth/oidc"},
+		{"untrusted client", "192.0.2.2:1234", "public.example.com", "http://internal.example.com/oidc"},
+		{"host not allowed", "10.1.2.3:1234", "evil.example.com", "https://internal.example.com/auth/oidc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var issuer string
+			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				issuer = ExternalIssuer(r.Context(), "http://internal.example.com/oidc")
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/oidc/.well-known/openid-configuration", nil)
+			request.RemoteAddr = test.remoteAddr
+			request.Header.Set(ForwardedProtoHeader, "https")
+			request.Header.Set(ForwardedHostHeader, test.host)
+			request.Header.Set(ForwardedPrefixHeader, "/auth")
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if issuer != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, issuer)
+			}
+			if vary := recorder.Header().Get("Vary"); vary != forwardedVary {
+				t.Errorf("expected Vary %s, got %s", forwardedVary, vary)
+			}
+		})
+	}
+}
+
+func TestForwardedConfigValidate(t *testing.T) {
+	for _, config := range []ForwardedConfig{
+		{},
+		{TrustedProxies: []string{"10.0.0.0/33"}},
+		{TrustedProxies: []string{"proxy.example.com"}},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("%+v is valid", config)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
+	"net/http"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-Id"
+
+// statusRecorder records the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(body []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	n, err := recorder.ResponseWriter.Write(body)
+	recorder.bytes += n
+	return n, err
+}
+
+// RequestIDMiddleware puts the request ID of X-Request-Id, or a new one if absent, into the request context and the response header,
+// so that logs of providers serving the request can be correlated with its access log
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// AccessLogMiddleware logs every request after it is served. it must run after RequestIDMiddleware to log the request ID.
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		logging.FromContext(r.Context()).Infow("access",
+			"method", r.Method,
+			"path", r.URL.RequestURI(),
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration", time.Since(start).String(),
+			"remoteAddr", r.RemoteAddr,
+			"userAgent", r.UserAgent(),
+		)
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(id)
+}
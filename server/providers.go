@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/krafton-hq/oidc-discovery-server/issuer_provider"
+	"github.com/krafton-hq/oidc-discovery-server/key_provider"
+	"github.com/zitadel/oidc/v2/pkg/op"
+	"sync/atomic"
+)
+
+// Providers is a provider graph built from config
+type Providers struct {
+	KeyProvider     op.KeyProvider
+	HTTPKeyProvider *key_provider.HTTPKeyProvider
+	IssuerPolicy    *issuer_provider.PolicyIssuerProvider
+
+	closers []func()
+}
+
+// OnClose registers a function releasing resources of the providers, e.g. informers and background goroutines
+func (providers *Providers) OnClose(closer func()) {
+	providers.closers = append(providers.closers, closer)
+}
+
+func (providers *Providers) Close() {
+	for _, closer := range providers.closers {
+		closer()
+	}
+}
+
+// ProvidersHolder holds the Providers used by handlers. it can be swapped while serving.
+type ProvidersHolder struct {
+	current atomic.Pointer[Providers]
+}
+
+func NewProvidersHolder(providers *Providers) *ProvidersHolder {
+	holder := &ProvidersHolder{}
+	holder.current.Store(providers)
+
+	return holder
+}
+
+func (holder *ProvidersHolder) Get() *Providers {
+	return holder.current.Load()
+}
+
+// Swap replaces the current providers and returns the old one
+func (holder *ProvidersHolder) Swap(providers *Providers) *Providers {
+	return holder.current.Swap(providers)
+}
@@ -2,22 +2,39 @@
 
 import (
 	"context"
+	"crypto/rsa"
 	"encoding/json"
 	"github.com/gorilla/mux"
 	"github.com/krafton-hq/oidc-discovery-server/jwt"
 	"github.com/krafton-hq/oidc-discovery-server/key_provider"
+	"github.com/krafton-hq/oidc-discovery-server/util/logging"
 	"github.com/pkg/errors"
 	"github.com/zitadel/oidc/v2/pkg/oidc"
 	"github.com/zitadel/oidc/v2/pkg/op"
+	"gopkg.in/square/go-jose.v2"
 	"net/http"
 	"net/url"
+	"sort"
 )
 
 const KeysPath = "/keys"
+const KeysIssuerPath = KeysPath + "/{issuer}"
+const StatusPath = "/status"
+const ReadinessPath = "/readyz"
+const LogLevelPath = "/admin/loglevel"
 
-func RegisterHandler(router *mux.Router, issuer string, keyProvider op.KeyProvider, httpKeyProvider *key_provider.HTTPKeyProvider) error {
-	OIDCHTTPHandler(router, httpKeyProvider)
-	err := OIDCHandler(router, issuer, keyProvider)
+// RegisterHandler registers all handlers. every request uses the providers currently held by providers.
+func RegisterHandler(router *mux.Router, issuer string, providers *ProvidersHolder) error {
+	StatusHandler(router, providers)
+	ReadinessHandler(router, providers)
+	OIDCHTTPHandler(router, providers)
+
+	return RegisterPublicHandler(router, issuer, providers)
+}
+
+// RegisterPublicHandler registers discovery and keys handlers only, which are safe to expose to the internet
+func RegisterPublicHandler(router *mux.Router, issuer string, providers *ProvidersHolder) error {
+	err := OIDCHandler(router, issuer, providers)
 	if err != nil {
 		return err
 	}
@@ -26,49 +43,170 @@ func RegisterHandler(router *mux.Router, issuer string, keyProvider op.KeyProvid
 }
 
 // TODO: log error on error handling
-func OIDCHandler(router *mux.Router, issuer string, keyProvider op.KeyProvider) error {
+func OIDCHandler(router *mux.Router, issuer string, providers *ProvidersHolder) error {
+	if _, err := discoveryConfiguration(issuer, nil); err != nil {
+		return err
+	}
+
+	router.HandleFunc(jwt.OIDCDocumentPath, func(w http.ResponseWriter, r *http.Request) {
+		// issuer is validated above, and ExternalIssuer only replaces parts of it by sanitized values
+		discoveryConf, _ := discoveryConfiguration(ExternalIssuer(r.Context(), issuer), signingAlgorithms(r.Context(), providers.Get()))
+		op.Discover(w, discoveryConf)
+	})
+
+	router.HandleFunc(KeysPath, func(w http.ResponseWriter, r *http.Request) {
+		op.Keys(w, r, providers.Get().KeyProvider)
+	})
+
+	return nil
+}
+
+func discoveryConfiguration(issuer string, algorithms []string) (*oidc.DiscoveryConfiguration, error) {
 	jwksUri, err := url.JoinPath(issuer, KeysPath)
 	if err != nil {
-		return errors.Wrap(err, "failed to join issuer and keys path. is issuer a valid url?")
+		return nil, errors.Wrap(err, "failed to join issuer and keys path. is issuer a valid url?")
 	}
 
-	discoveryConf := oidc.DiscoveryConfiguration{
+	return &oidc.DiscoveryConfiguration{
 		Issuer:                           issuer,
 		JwksURI:                          jwksUri,
-		IDTokenSigningAlgValuesSupported: []string{"RS256"},
+		IDTokenSigningAlgValuesSupported: algorithms,
+	}, nil
+}
+
+// signingAlgorithms returns algorithms of cached keys of every key provider, sorted. only keys of trusted issuers are used and
+// no issuer is fetched, so that discovery requests can't trigger key lookups. RS256 is returned if no key is cached yet,
+// since id_token_signing_alg_values_supported is required.
+func signingAlgorithms(ctx context.Context, providers *Providers) []string {
+	keys := make([]op.Key, 0)
+	if cached, ok := providers.KeyProvider.(key_provider.CachedKeyProvider); ok {
+		keys = cached.CachedKeys(ctx)
 	}
 
-	router.HandleFunc(jwt.OIDCDocumentPath, func(w http.ResponseWriter, r *http.Request) {
-		op.Discover(w, &discoveryConf)
+	algorithms := make([]string, 0)
+	found := make(map[string]struct{})
+	for _, key := range keys {
+		algorithm := string(key.Algorithm())
+		if _, ok := key.Key().(*rsa.PublicKey); ok && algorithm == "" {
+			// RSA keys without alg can be used with any RSA algorithm. RS256 is the one every relying party supports.
+			algorithm = string(jose.RS256)
+		}
+		if _, ok := found[algorithm]; ok || algorithm == "" || key.Use() == "enc" {
+			continue
+		}
+
+		found[algorithm] = struct{}{}
+		algorithms = append(algorithms, algorithm)
+	}
+
+	if len(algorithms) == 0 {
+		return []string{string(jose.RS256)}
+	}
+
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+// OIDCHTTPHandler serves keys of a trusted issuer given by the issuer query parameter, for debugging
+func OIDCHTTPHandler(router *mux.Router, providers *ProvidersHolder) {
+	router.HandleFunc(KeysIssuerPath, func(w http.ResponseWriter, r *http.Request) {
+		writeIssuerKeys(w, r, providers.Get())
 	})
+}
 
-	router.HandleFunc(KeysPath, func(w http.ResponseWriter, r *http.Request) {
-		op.Keys(w, r, keyProvider)
+func writeIssuerKeys(w http.ResponseWriter, r *http.Request, providers *Providers) {
+	issuer := r.URL.Query().Get("issuer")
+
+	keySet, err := providers.HTTPKeyProvider.GetKeySetFromIssuer(r.Context(), issuer, false)
+	if errors.Is(err, key_provider.ErrUntrustedIssuer) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	keys := make([]jose.JSONWebKey, 0)
+	for _, key := range keySet.Keys() {
+		keys = append(keys, jwt.PublicJSONWebKey(key))
+	}
+
+	body, err := json.Marshal(keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func StatusHandler(router *mux.Router, providers *ProvidersHolder) {
+	router.HandleFunc(StatusPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, providersStatus(r.Context(), providers.Get()))
 	})
+}
+
+func providersStatus(ctx context.Context, providers *Providers) map[string]interface{} {
+	trustedIssuers, issuerErr := providers.IssuerPolicy.Issuers(ctx)
+
+	status := map[string]interface{}{
+		"issuers":         providers.HTTPKeyProvider.Status(),
+		"trustedIssuers":  trustedIssuers,
+		"rejectedIssuers": providers.IssuerPolicy.Rejected(),
+	}
+	if issuerErr != nil {
+		status["issuerProviderError"] = issuerErr.Error()
+	}
+
+	return status
+}
+
+// ReadinessHandler reports not ready if issuer providers fail without any issuer to serve,
+// e.g. the issuer registry is down since startup. a failing provider with last known issuers is still ready.
+func ReadinessHandler(router *mux.Router, providers *ProvidersHolder) {
+	router.HandleFunc(ReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		writeReadiness(w, r, providersReady(r.Context(), providers.Get()))
+	})
+}
+
+func providersReady(ctx context.Context, providers *Providers) error {
+	issuers, err := providers.IssuerPolicy.Issuers(ctx)
+	if err != nil && len(issuers) == 0 {
+		return err
+	}
 
 	return nil
 }
 
-func OIDCHTTPHandler(router *mux.Router, keyProvider *key_provider.HTTPKeyProvider) {
-	keysIssuerPath, _ := url.JoinPath(KeysPath, "{issuer}")
-	router.HandleFunc(keysIssuerPath, func(w http.ResponseWriter, r *http.Request) {
-		issuer := r.URL.Query().Get("issuer")
+func writeReadiness(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
-		keySet, err := keyProvider.GetKeySetFromIssuer(context.TODO(), issuer, false)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logging.FromContext(r.Context()).Warnf("failed to write readiness response. %v", err)
+	}
+}
 
-		body, err := json.Marshal(keySet.Keys())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		if _, err := w.Write(body); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-	})
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// LogLevelHandler serves the level of the global logger. GET returns the level and PUT with {"level": "debug"} changes it.
+func LogLevelHandler(router *mux.Router) {
+	router.Handle(LogLevelPath, logging.Level).Methods(http.MethodGet, http.MethodPut)
 }
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// VirtualIssuer is an aggregated issuer served by this server with its own providers.
+// requests are routed to it by the path of Issuer, and also by its host if MatchHost.
+type VirtualIssuer struct {
+	Name      string
+	Issuer    string
+	MatchHost bool
+	Providers *ProvidersHolder
+
+	host   string
+	prefix string
+	router *mux.Router
+}
+
+// NewVirtualIssuer creates a virtual issuer serving all handlers, or discovery and keys handlers only if publicOnly
+func NewVirtualIssuer(name, issuer string, matchHost bool, providers *Providers, publicOnly bool) (*VirtualIssuer, error) {
+	parsed, err := url.Parse(issuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "issuer of %s is not a valid URL", name)
+	}
+
+	virtualIssuer := &VirtualIssuer{
+		Name:      name,
+		Issuer:    issuer,
+		MatchHost: matchHost,
+		Providers: NewProvidersHolder(providers),
+		host:      strings.ToLower(parsed.Hostname()),
+		prefix:    strings.TrimSuffix(parsed.Path, "/"),
+		router:    mux.NewRouter(),
+	}
+
+	router := virtualIssuer.router
+	if virtualIssuer.prefix != "" {
+		router = router.PathPrefix(virtualIssuer.prefix).Subrouter()
+	}
+	register := RegisterHandler
+	if publicOnly {
+		register = RegisterPublicHandler
+	}
+	if err := register(router, issuer, virtualIssuer.Providers); err != nil {
+		return nil, errors.Wrapf(err, "failed to register handler of %s", name)
+	}
+
+	return virtualIssuer, nil
+}
+
+// matches returns the length of the matched path prefix, -1 if r is not for this issuer
+func (virtualIssuer *VirtualIssuer) matches(r *http.Request) int {
+	if virtualIssuer.MatchHost && requestHost(r) != virtualIssuer.host {
+		return -1
+	}
+
+	path := r.URL.Path
+	if path != virtualIssuer.prefix && !strings.HasPrefix(path, virtualIssuer.prefix+"/") {
+		return -1
+	}
+
+	return len(virtualIssuer.prefix)
+}
+
+// IssuerRouter routes requests to the virtual issuer with the longest matching path.
+// among issuers of the same path, one matching the host is preferred. issuers can be replaced while serving.
+type IssuerRouter struct {
+	issuers atomic.Pointer[[]*VirtualIssuer]
+}
+
+func NewIssuerRouter(issuers []*VirtualIssuer) *IssuerRouter {
+	router := &IssuerRouter{}
+	router.Set(issuers)
+
+	return router
+}
+
+func (router *IssuerRouter) Get() []*VirtualIssuer {
+	return *router.issuers.Load()
+}
+
+// Set replaces virtual issuers and returns the old ones
+func (router *IssuerRouter) Set(issuers []*VirtualIssuer) []*VirtualIssuer {
+	old := router.issuers.Swap(&issuers)
+	if old == nil {
+		return nil
+	}
+
+	return *old
+}
+
+func (router *IssuerRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var matched *VirtualIssuer
+	matchedLength := -1
+
+	for _, virtualIssuer := range router.Get() {
+		length := virtualIssuer.matches(r)
+		if length > matchedLength || (length == matchedLength && length >= 0 && virtualIssuer.MatchHost && !matched.MatchHost) {
+			matched, matchedLength = virtualIssuer, length
+		}
+	}
+
+	if matched == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	matched.router.ServeHTTP(w, r)
+}
+
+// requestHost returns the host requested by the client, which is forwarded by the proxy if ForwardedMiddleware is used
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if forwarded, ok := ForwardedFromContext(r.Context()); ok && forwarded.Host != "" {
+		host = forwarded.Host
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return strings.ToLower(host)
+}
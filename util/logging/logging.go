@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+type requestIDKey struct{}
+
+// Level is the level of the global logger. it can be changed at runtime, e.g. by its ServeHTTP
+var Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+
+// Setup replaces the global logger with one of format whose level is Level
+func Setup(level, format string) error {
+	if err := SetLevel(level); err != nil {
+		return err
+	}
+
+	config := zap.NewProductionConfig()
+	config.Level = Level
+
+	switch format {
+	case "", FormatJSON:
+		config.Encoding = FormatJSON
+	case FormatConsole:
+		config.Encoding = FormatConsole
+		config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	default:
+		return errors.Errorf("unknown log format %s. must be one of %s, %s", format, FormatJSON, FormatConsole)
+	}
+
+	logger, err := config.Build()
+	if err != nil {
+		return errors.Wrap(err, "failed to build logger")
+	}
+
+	zap.ReplaceGlobals(logger)
+	return nil
+}
+
+// SetLevel sets Level. empty level is info.
+func SetLevel(level string) error {
+	if level == "" {
+		level = zap.InfoLevel.String()
+	}
+
+	parsed, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	Level.SetLevel(parsed)
+	return nil
+}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request ID of ctx, empty if ctx is not of a request
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// FromContext returns the global logger with the request ID of ctx, if any
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if requestID := RequestID(ctx); requestID != "" {
+		return zap.S().With("requestId", requestID)
+	}
+
+	return zap.S()
+}
+
+// Detach returns a context without the deadline and cancellation of ctx but with its request ID,
+// for background work started by a request
+func Detach(ctx context.Context) context.Context {
+	if requestID := RequestID(ctx); requestID != "" {
+		return WithRequestID(context.Background(), requestID)
+	}
+
+	return context.Background()
+}